@@ -1,11 +1,12 @@
 module github.com/quay/zlog
 
-go 1.17
+go 1.22.0
 
 require (
-	github.com/google/go-cmp v0.6.0
+	github.com/google/go-cmp v0.7.0
 	github.com/rs/zerolog v1.32.0
-	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel v1.35.0
+	go.opentelemetry.io/otel/trace v1.35.0
 )
 
 require (
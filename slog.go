@@ -0,0 +1,233 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Handler is the slog.Handler used by the package-level functions, if set.
+var handler slog.Handler
+
+// SetHandler configures the package-level functions to emit records to the
+// provided [slog.Handler], such as one returned by the
+// "github.com/quay/zlog/v2" package.
+//
+// The zerolog chained field API is kept, but every event is converted into an
+// [slog.Record] and handled with the [context.Context] passed to the
+// package-level function. The Handler is responsible for any contextual
+// information; the OpenTelemetry baggage is not added to events as fields.
+// The Handler's Enabled method is consulted before an event is created, in
+// addition to the zerolog global level.
+//
+// Calling [Set] undoes the effects of this function. This function is unsafe
+// to use concurrently with the other functions of this package.
+func SetHandler(h slog.Handler) {
+	handler = h
+}
+
+// NewLogger returns a zerolog Logger that converts every event into an
+// [slog.Record] and sends it to "h".
+//
+// Events are handled with [context.Background]; use [SetHandler] and the
+// package-level functions for contextual logging.
+func NewLogger(h slog.Handler) zerolog.Logger {
+	return zerolog.New(&slogWriter{h: h, ctx: context.Background()})
+}
+
+// HandlerEvent starts a new event that will be sent to "h".
+func handlerEvent(ctx context.Context, h slog.Handler, l zerolog.Level) *zerolog.Event {
+	if !h.Enabled(ctx, slogLevel(l)) {
+		return nil
+	}
+	zl := zerolog.New(&slogWriter{h: h, ctx: ctx})
+	return zl.WithLevel(l)
+}
+
+// SlogLevel maps a zerolog level to an slog level.
+//
+// The levels above error use the syslog-compatible levels of the v2 package.
+func slogLevel(l zerolog.Level) slog.Level {
+	switch l {
+	case zerolog.TraceLevel:
+		return slog.LevelDebug - 4
+	case zerolog.DebugLevel:
+		return slog.LevelDebug
+	case zerolog.InfoLevel, zerolog.NoLevel:
+		return slog.LevelInfo
+	case zerolog.WarnLevel:
+		return slog.LevelWarn
+	case zerolog.ErrorLevel:
+		return slog.LevelError
+	case zerolog.FatalLevel:
+		return slog.LevelError + 4
+	case zerolog.PanicLevel:
+		return slog.LevelError + 12
+	}
+	return slog.LevelInfo
+}
+
+// SlogWriter is a [zerolog.LevelWriter] that decodes the JSON-encoded events
+// and sends them to an slog.Handler.
+//
+// The facade functions construct one per event, so that the Context is
+// available when the record is handled.
+type slogWriter struct {
+	h   slog.Handler
+	ctx context.Context
+}
+
+var (
+	_ io.Writer           = (*slogWriter)(nil)
+	_ zerolog.LevelWriter = (*slogWriter)(nil)
+)
+
+// Write implements [io.Writer].
+func (w *slogWriter) Write(b []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, b)
+}
+
+// WriteLevel implements [zerolog.LevelWriter].
+func (w *slogWriter) WriteLevel(l zerolog.Level, b []byte) (int, error) {
+	r := slog.NewRecord(time.Now(), slogLevel(l), "", callerPC())
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil { // Opening brace.
+		return 0, err
+	}
+	for dec.More() {
+		k, v, err := decodeField(dec)
+		if err != nil {
+			return 0, err
+		}
+		switch k {
+		case zerolog.LevelFieldName:
+			continue
+		case zerolog.MessageFieldName:
+			if s, ok := v.(string); ok {
+				r.Message = s
+				continue
+			}
+		case zerolog.TimestampFieldName:
+			if s, ok := v.(string); ok {
+				if t, err := time.Parse(zerolog.TimeFieldFormat, s); err == nil {
+					r.Time = t
+					continue
+				}
+			}
+		}
+		r.AddAttrs(toAttr(k, v))
+	}
+	if err := w.h.Handle(w.ctx, r); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// CallerPC reports the program counter of the first caller outside of
+// zerolog.
+//
+// The facade functions have already returned by the time an event is written,
+// so only zerolog's frames need to be skipped.
+func callerPC() uintptr {
+	var pcs [16]uintptr
+	n := runtime.Callers(3, pcs[:]) // Skip [runtime.Callers, callerPC, WriteLevel].
+	for _, pc := range pcs[:n] {
+		f, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+		if strings.HasPrefix(f.Function, "github.com/rs/zerolog") {
+			continue
+		}
+		return pc
+	}
+	return 0
+}
+
+// DecodeField reads a key and a value from the decoder.
+//
+// Objects are returned as []slog.Attr, numbers as json.Number.
+func decodeField(dec *json.Decoder) (string, any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return "", nil, err
+	}
+	k, _ := t.(string)
+	v, err := decodeValue(dec)
+	return k, v, err
+}
+
+// DecodeValue reads a value from the decoder.
+func decodeValue(dec *json.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		var as []slog.Attr
+		for dec.More() {
+			k, v, err := decodeField(dec)
+			if err != nil {
+				return nil, err
+			}
+			as = append(as, toAttr(k, v))
+		}
+		_, err := dec.Token()
+		return as, err
+	case json.Delim('['):
+		vs := []any{}
+		for dec.More() {
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			if as, ok := v.([]slog.Attr); ok {
+				v = attrsToMap(as)
+			}
+			vs = append(vs, v)
+		}
+		_, err := dec.Token()
+		return vs, err
+	}
+	return t, nil
+}
+
+// ToAttr converts a decoded value to an Attr.
+func toAttr(k string, v any) slog.Attr {
+	switch v := v.(type) {
+	case []slog.Attr:
+		return slog.Attr{Key: k, Value: slog.GroupValue(v...)}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return slog.Int64(k, i)
+		}
+		if f, err := v.Float64(); err == nil {
+			return slog.Float64(k, f)
+		}
+		return slog.String(k, v.String())
+	case string:
+		return slog.String(k, v)
+	case bool:
+		return slog.Bool(k, v)
+	}
+	return slog.Any(k, v)
+}
+
+// AttrsToMap converts decoded Attrs back to a map, for objects inside arrays.
+func attrsToMap(as []slog.Attr) map[string]any {
+	m := make(map[string]any, len(as))
+	for _, a := range as {
+		if a.Value.Kind() == slog.KindGroup {
+			m[a.Key] = attrsToMap(a.Value.Group())
+			continue
+		}
+		m[a.Key] = a.Value.Any()
+	}
+	return m
+}
@@ -0,0 +1,80 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rs/zerolog"
+)
+
+func TestSetHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		AddSource: true,
+		Level:     slog.LevelDebug,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			switch a.Key {
+			case slog.TimeKey:
+				return slog.Attr{}
+			case slog.SourceKey:
+				a.Value = slog.StringValue(a.Value.Any().(*slog.Source).Function)
+			}
+			return a
+		},
+	})
+	defer Set(log)
+	SetHandler(h)
+	ctx := context.Background()
+
+	Trace(ctx).Msg("not enabled")
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+	Warn(ctx).
+		Str("string", "value").
+		Int("int", 1).
+		Float64("float", 1.5).
+		Bool("bool", true).
+		Err(errors.New("oops")).
+		Dict("dict", zerolog.Dict().Str("nested", "value")).
+		Ints("ints", []int{1, 2}).
+		Msg("message")
+
+	got := make(map[string]any)
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"level":  "WARN",
+		"source": "github.com/quay/zlog.TestSetHandler",
+		"msg":    "message",
+		"string": "value",
+		"int":    float64(1),
+		"float":  1.5,
+		"bool":   true,
+		"error":  "oops",
+		"dict":   map[string]any{"nested": "value"},
+		"ints":   []any{float64(1), float64(2)},
+	}
+	if !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+}
+
+func TestSetHandlerContextLevel(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewJSONHandler(&buf, nil)
+	defer Set(log)
+	SetHandler(h)
+	ctx := ContextWithLevel(context.Background(), zerolog.DebugLevel)
+	Debug(ctx).Msg("shown")
+	Trace(ctx).Msg("hidden")
+	if got, want := bytes.Count(buf.Bytes(), []byte("\n")), 1; got != want {
+		t.Errorf("got: %d records, want: %d:\n%s", got, want, buf.String())
+	}
+}
@@ -1,10 +1,13 @@
 package zlog
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"runtime"
+	"runtime/pprof"
 	"strconv"
 	"sync"
 	"testing"
@@ -25,19 +28,43 @@ var (
 // Test configures and wires up the global logger for testing.
 //
 // Once called, log messages that do not use a Context returned by a call to
-// Test will cause a panic.
+// Test are attributed to a test by the goroutine they're written from: the
+// goroutine that called Test, or any goroutine started from it, however
+// indirectly. Messages that still can't be attributed are handled according
+// to the [OrphanPolicy], which by default causes a panic.
+//
+// Messages are handled according to the [TestOptions] set via
+// [SetTestOptions].
 //
 // Passing a nil Context will return a Context derived from context.Background.
 func Test(ctx context.Context, t testing.TB) context.Context {
+	t.Helper()
+	return TestWithOptions(ctx, t, nil)
+}
+
+// TestWithOptions is like [Test], but uses the provided options for the test
+// "t" instead of those set via [SetTestOptions].
+func TestWithOptions(ctx context.Context, t testing.TB, opts *TestOptions) context.Context {
 	t.Helper()
 	setup.Do(sink.Setup)
 	t.Cleanup(func() {
 		sink.Remove(t)
 	})
-	sink.Create(t)
+	sink.Create(t, opts)
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	// Goroutines inherit the labels of the goroutine that starts them, so
+	// this marks every goroutine started from here on, at any depth. Like
+	// [pprof.Do], the labels are added to those in "ctx", which are put back
+	// once the test is done.
+	id := goroutineID()
+	pprof.SetGoroutineLabels(pprof.WithLabels(ctx, pprof.Labels(testNameKey, t.Name())))
+	t.Cleanup(func() {
+		if goroutineID() == id {
+			pprof.SetGoroutineLabels(ctx)
+		}
+	})
 	m, err := baggage.NewMember(testNameKey, t.Name())
 	if err != nil {
 		t.Fatal(err)
@@ -49,15 +76,55 @@ func Test(ctx context.Context, t testing.TB) context.Context {
 	return baggage.ContextWithBaggage(ctx, b)
 }
 
+// TestOptions configures how the test harness handles the messages for a test.
+//
+// The zero value logs every message as soon as it's written, and is the
+// default used by [Test].
+type TestOptions struct {
+	// FailedOnly causes messages to be held and only logged, when the test
+	// finishes, if the test failed.
+	FailedOnly bool
+	// MinLevel, if set, is the minimum level of messages to be logged to the
+	// test. Messages below the level are still available via [Records].
+	MinLevel *zerolog.Level
+	// Keep is the number of most recent messages held when FailedOnly is set.
+	// The zero value means no limit.
+	Keep int
+}
+
+// DefaultTestOptions are the options used by [Test].
+var defaultTestOptions TestOptions
+
+// SetTestOptions sets the options used for tests that are set up by [Test].
+//
+// Passing nil restores the default, which is to log every message as soon as
+// it's written.
+func SetTestOptions(opts *TestOptions) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	defaultTestOptions = TestOptions{}
+	if opts != nil {
+		defaultTestOptions = *opts
+	}
+}
+
 // Logsink holds the files and does the routing for log messages.
 type logsink struct {
 	mu sync.RWMutex
-	ts map[string]testing.TB
+	ts map[string]*testStream
+	// Gs maps goroutine IDs to test names.
+	gs map[uint64]string
+	// Fields for handling orphaned messages:
+	policy   OrphanPolicy
+	fallback testing.TB
+	orphans  [][]byte
+	dropped  int
 }
 
 // Setup configures the logsink and configures the logger.
 func (s *logsink) Setup() {
-	s.ts = make(map[string]testing.TB)
+	s.ts = make(map[string]*testStream)
+	s.gs = make(map[uint64]string)
 
 	// Set up caller information be default, because the testing package's line
 	// information will be incorrect.
@@ -68,35 +135,293 @@ func (s *logsink) Setup() {
 	Set(&l)
 }
 
+// TestStream is the per-test state.
+type testStream struct {
+	// Mu protects the records and held messages, as messages are delivered
+	// with the logsink's read lock held.
+	mu   sync.Mutex
+	t    testing.TB
+	opts TestOptions
+	recs []Record
+	// Held is messages held because of the FailedOnly option.
+	held [][]byte
+}
+
+// Deliver records the message "b" and logs it according to the options.
+func (ts *testStream) Deliver(b []byte) {
+	r := newRecord(b)
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.recs = append(ts.recs, r)
+	if min := ts.opts.MinLevel; min != nil && r.Level < *min {
+		return
+	}
+	if !ts.opts.FailedOnly {
+		ts.t.Log(string(bytes.TrimSuffix(b, []byte{'\n'})))
+		return
+	}
+	if k := ts.opts.Keep; k > 0 && len(ts.held) >= k {
+		ts.held = ts.held[1:]
+	}
+	ts.held = append(ts.held, append([]byte(nil), b...))
+}
+
 // Create initializes a new log stream.
-func (s *logsink) Create(t testing.TB) {
+func (s *logsink) Create(t testing.TB, opts *TestOptions) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.ts[t.Name()] = t
+	if opts == nil {
+		opts = &defaultTestOptions
+	}
+	s.ts[t.Name()] = &testStream{t: t, opts: *opts}
+	s.gs[goroutineID()] = t.Name()
 }
 
 // Remove tears down a log stream.
+//
+// If messages were held because of the FailedOnly option and the test failed,
+// they're logged.
 func (s *logsink) Remove(t testing.TB) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if ts, ok := s.ts[t.Name()]; ok && t.Failed() && len(ts.held) != 0 {
+		t.Logf("zlog: replaying %d held message(s):", len(ts.held))
+		for _, b := range ts.held {
+			t.Log(string(bytes.TrimSuffix(b, []byte{'\n'})))
+		}
+	}
 	delete(s.ts, t.Name())
+	for id, n := range s.gs {
+		if n == t.Name() {
+			delete(s.gs, id)
+		}
+	}
 }
 
 // Write routes writes to the correct stream.
 func (s *logsink) Write(b []byte) (int, error) {
 	var ev ev
-	if err := json.Unmarshal(b, &ev); err != nil {
-		return -1, err
+	decErr := json.Unmarshal(b, &ev)
+	name := ev.Name
+	if decErr != nil || !s.has(name) {
+		name = s.attribute()
+	}
+	s.mu.RLock()
+	if ts, ok := s.ts[name]; ok {
+		defer s.mu.RUnlock()
+		ts.Deliver(b)
+		return len(b), nil
 	}
-	l := len(b)
+	s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.orphan(b, ev.Name, decErr)
+}
+
+// Has reports whether there's a stream for the test "name".
+func (s *logsink) has(name string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	t, ok := s.ts[ev.Name]
+	_, ok := s.ts[name]
+	return ok
+}
+
+// Attribute finds the test associated with the current goroutine: the test
+// that started it, directly or through other goroutines, or the empty string
+// if there's none. The result is cached by goroutine ID, so the goroutine
+// profile is only taken once per goroutine.
+func (s *logsink) attribute() string {
+	id := goroutineID()
+	s.mu.RLock()
+	n, ok := s.gs[id]
+	s.mu.RUnlock()
+	if ok {
+		return n
+	}
+	n, ok = goroutineTest()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, live := s.ts[n]; !ok || !live {
+		// Labels outlive the test, so a goroutine leaked from a finished
+		// test may still carry them.
+		n = ""
+	}
+	s.gs[id] = n
+	return n
+}
+
+// Orphan handles a message that can't be attributed to a test, according to
+// the configured policy.
+//
+// The caller must hold the lock.
+func (s *logsink) orphan(b []byte, name string, decErr error) (int, error) {
+	switch s.policy {
+	case OrphanFallback:
+		if s.fallback != nil {
+			s.fallback.Log(string(bytes.TrimSuffix(b, []byte{'\n'})))
+			break
+		}
+		fallthrough
+	case OrphanDrop:
+		s.dropped++
+	case OrphanBuffer:
+		if len(s.orphans) >= maxOrphans {
+			s.orphans = s.orphans[1:]
+			s.dropped++
+		}
+		s.orphans = append(s.orphans, append([]byte(nil), b...))
+	default:
+		if decErr != nil {
+			return 0, decErr
+		}
+		panic(fmt.Sprintf("log write to unknown test %q:\n%s", name, string(b)))
+	}
+	return len(b), nil
+}
+
+// MaxOrphans is the number of orphaned messages buffered under the
+// OrphanBuffer policy. Once full, the oldest message is dropped.
+const maxOrphans = 1024
+
+// OrphanPolicy controls what the test harness does with "orphaned" log
+// messages: messages that can't be attributed to a test.
+//
+// Messages are attributed to a test by the Context returned from [Test] or by
+// the goroutine they're written from. Orphans are typically written by
+// background goroutines or third-party code.
+type OrphanPolicy int
+
+// These are the available OrphanPolicy values.
+const (
+	// OrphanPanic causes orphaned messages to panic the program. Messages that
+	// fail to decode are reported as a write error.
+	OrphanPanic OrphanPolicy = iota
+	// OrphanFallback causes orphaned messages to be logged to the fallback
+	// [testing.TB] passed to [SetOrphanPolicy]. If that's nil, messages are
+	// dropped.
+	OrphanFallback
+	// OrphanBuffer causes orphaned messages to be held until a test claims
+	// them via [ClaimOrphans].
+	OrphanBuffer
+	// OrphanDrop causes orphaned messages to be dropped and counted. See
+	// [DroppedOrphans].
+	OrphanDrop
+)
+
+// SetOrphanPolicy configures how the test harness handles orphaned log
+// messages. The "fallback" argument is only used with [OrphanFallback], and
+// must outlive any logging.
+func SetOrphanPolicy(p OrphanPolicy, fallback testing.TB) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.policy = p
+	sink.fallback = fallback
+}
+
+// ClaimOrphans logs any orphaned messages held under the [OrphanBuffer] policy
+// to the test "t", which must have been passed to [Test].
+func ClaimOrphans(t testing.TB) {
+	t.Helper()
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	ts, ok := sink.ts[t.Name()]
 	if !ok {
-		panic(fmt.Sprintf("log write to unknown test %q:\n%s", ev.Name, string(b)))
+		t.Fatal("zlog: ClaimOrphans called without Test")
+	}
+	for _, b := range sink.orphans {
+		ts.Deliver(b)
 	}
-	t.Log(string(b[:l-1]))
-	return l, nil
+	sink.orphans = nil
+}
+
+// DroppedOrphans reports the number of orphaned messages that have been
+// dropped.
+func DroppedOrphans() int {
+	sink.mu.RLock()
+	defer sink.mu.RUnlock()
+	return sink.dropped
+}
+
+// GoroutineID reports the ID of the current goroutine, as reported in a stack
+// trace.
+func goroutineID() (id uint64) {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	// The first line looks like "goroutine 18 [running]:".
+	if rest, ok := bytes.CutPrefix(buf[:n], []byte("goroutine ")); ok {
+		if i := bytes.IndexByte(rest, ' '); i != -1 {
+			id, _ = strconv.ParseUint(string(rest[:i]), 10, 64)
+		}
+	}
+	return id
+}
+
+// ProfileMu serializes calls to [goroutineTest].
+var profileMu sync.Mutex
+
+// GoroutineTest reports the test name in the profiler labels of the current
+// goroutine, as set by [TestWithOptions].
+//
+// There's no API for reading the labels of the current goroutine, so they're
+// found in a goroutine profile, in the entry for the goroutine inside
+// goroutineTest that's writing the profile. Because of profileMu, there's
+// only one such goroutine.
+func goroutineTest() (string, bool) {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return "", false
+	}
+	// Entries are separated by blank lines, and look like:
+	//
+	//	1 @ 0x43a0d6 0x4729f5
+	//	# labels: {"zlog.testname":"TestName"}
+	//	#	0x4729f4	runtime/pprof.writeGoroutine+0x34	/usr/lib/go/...
+	for _, e := range bytes.Split(buf.Bytes(), []byte("\n\n")) {
+		if !bytes.Contains(e, []byte(".goroutineTest+")) || !bytes.Contains(e, []byte("pprof.writeGoroutine+")) {
+			continue
+		}
+		_, ls, ok := bytes.Cut(e, []byte("\n# labels: "))
+		if !ok {
+			return "", false
+		}
+		ls, _, _ = bytes.Cut(ls, []byte{'\n'})
+		_, v, ok := bytes.Cut(ls, []byte(strconv.Quote(testNameKey)+":"))
+		if !ok {
+			return "", false
+		}
+		q, err := strconv.QuotedPrefix(string(v))
+		if err != nil {
+			return "", false
+		}
+		n, err := strconv.Unquote(q)
+		return n, err == nil
+	}
+	return "", false
+}
+
+// Records returns copies of the records logged for the test.
+func (s *logsink) Records(t testing.TB) []Record {
+	ts := s.Stream(t)
+	if ts == nil {
+		return nil
+	}
+	return ts.Records()
+}
+
+// Stream returns the stream for the test, or nil if there's none.
+func (s *logsink) Stream(t testing.TB) *testStream {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ts[t.Name()]
+}
+
+// Records returns copies of the records delivered to the stream.
+func (ts *testStream) Records() []Record {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]Record(nil), ts.recs...)
 }
 
 // Ev is used to pull the test name out of the zerolog Event.
@@ -0,0 +1,178 @@
+package zlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// Record is a log message captured by the test harness.
+type Record struct {
+	// Fields contains the decoded fields of the message, other than the
+	// level, message, and test name.
+	Fields map[string]any
+	// Message is the message, if any.
+	Message string
+	// Level is the level of the message. Messages without a level have
+	// [zerolog.NoLevel].
+	Level zerolog.Level
+}
+
+// NewRecord decodes a zerolog message into a Record.
+//
+// The message is assumed to be valid JSON, as it's already been decoded once.
+func newRecord(b []byte) Record {
+	r := Record{
+		Fields: make(map[string]any),
+		Level:  zerolog.NoLevel,
+	}
+	json.Unmarshal(b, &r.Fields)
+	if v, ok := r.Fields[zerolog.LevelFieldName].(string); ok {
+		if l, err := zerolog.ParseLevel(v); err == nil {
+			r.Level = l
+		}
+		delete(r.Fields, zerolog.LevelFieldName)
+	}
+	if v, ok := r.Fields[zerolog.MessageFieldName].(string); ok {
+		r.Message = v
+		delete(r.Fields, zerolog.MessageFieldName)
+	}
+	delete(r.Fields, testNameKey)
+	return r
+}
+
+// Records returns the messages logged so far for the test "t", which must
+// have been passed to [Test].
+func Records(t testing.TB) []Record {
+	return sink.Records(t)
+}
+
+// Expect returns an [Expectation] that a matching message is logged for the
+// test "t", which must have been passed to [Test].
+//
+// The Expectation is checked when the test finishes, and can be narrowed by
+// calling its methods:
+//
+//	zlog.Expect(t).Level(zerolog.WarnLevel).Msg("retrying").Field("attempt", 2)
+func Expect(t testing.TB) *Expectation {
+	t.Helper()
+	e := &Expectation{t: t, ts: sink.Stream(t), count: -1}
+	if e.ts == nil {
+		t.Fatal("zlog: Expect called without Test")
+	}
+	t.Cleanup(e.check)
+	return e
+}
+
+// NoErrors fails the test "t", which must have been passed to [Test], if any
+// messages at [zerolog.ErrorLevel] or above are logged.
+//
+// This is checked when the test finishes.
+func NoErrors(t testing.TB) {
+	t.Helper()
+	ts := sink.Stream(t)
+	if ts == nil {
+		t.Fatal("zlog: NoErrors called without Test")
+	}
+	t.Cleanup(func() {
+		t.Helper()
+		for _, r := range ts.Records() {
+			if r.Level >= zerolog.ErrorLevel && r.Level != zerolog.NoLevel {
+				t.Errorf("zlog: unexpected %v message logged: %q %v", r.Level, r.Message, r.Fields)
+			}
+		}
+	})
+}
+
+// Expectation is a description of messages expected to be logged by a test.
+//
+// Expectations are created by [Expect].
+type Expectation struct {
+	t testing.TB
+	// Ts is the stream for the test. It's kept so that the records are still
+	// available if the stream is removed before the Expectation is checked.
+	ts    *testStream
+	desc  []string
+	match []func(Record) bool
+	// Count is the expected number of matches, or -1 for "at least one".
+	count int
+}
+
+// Level narrows the Expectation to messages at level "l".
+func (e *Expectation) Level(l zerolog.Level) *Expectation {
+	e.desc = append(e.desc, "level "+l.String())
+	e.match = append(e.match, func(r Record) bool { return r.Level == l })
+	return e
+}
+
+// Msg narrows the Expectation to messages with the message "msg".
+func (e *Expectation) Msg(msg string) *Expectation {
+	e.desc = append(e.desc, fmt.Sprintf("message %q", msg))
+	e.match = append(e.match, func(r Record) bool { return r.Message == msg })
+	return e
+}
+
+// Field narrows the Expectation to messages with the field "key" equal to
+// "value".
+//
+// The value is compared as it would be after a round-trip through JSON, so
+// for example an int matches the decoded float64.
+func (e *Expectation) Field(key string, value any) *Expectation {
+	e.desc = append(e.desc, fmt.Sprintf("field %q = %v", key, value))
+	var want any
+	if b, err := json.Marshal(value); err == nil {
+		json.Unmarshal(b, &want)
+	}
+	e.match = append(e.match, func(r Record) bool {
+		got, ok := r.Fields[key]
+		return ok && reflect.DeepEqual(got, want)
+	})
+	return e
+}
+
+// Match narrows the Expectation to messages for which "f" reports true.
+func (e *Expectation) Match(f func(Record) bool) *Expectation {
+	e.desc = append(e.desc, "custom match")
+	e.match = append(e.match, f)
+	return e
+}
+
+// Times changes the Expectation to be exactly "n" matching messages.
+func (e *Expectation) Times(n int) *Expectation {
+	e.count = n
+	return e
+}
+
+// Never changes the Expectation to be no matching messages.
+func (e *Expectation) Never() *Expectation {
+	return e.Times(0)
+}
+
+// Check fails the test if the Expectation was not met.
+func (e *Expectation) check() {
+	e.t.Helper()
+	n := 0
+Record:
+	for _, r := range e.ts.Records() {
+		for _, m := range e.match {
+			if !m(r) {
+				continue Record
+			}
+		}
+		n++
+	}
+	desc := strings.Join(e.desc, ", ")
+	if desc == "" {
+		desc = "any message"
+	}
+	switch {
+	case e.count < 0 && n == 0:
+		e.t.Errorf("zlog: expected message with %s, found none", desc)
+	case e.count >= 0 && n != e.count:
+		e.t.Errorf("zlog: expected %d message(s) with %s, found %d", e.count, desc, n)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quay/zlog/v2/internal/hooks"
+	"github.com/quay/zlog/v2/internal/jsonattr"
+)
+
+// PollInterval is how often a followed file is checked for new data.
+const pollInterval = 250 * time.Millisecond
+
+// Copy copies the records in "r". If "follow" is set, reaching the end of "r"
+// waits for more data instead of returning, until "ctx" is done.
+func (v *viewer) copy(ctx context.Context, r io.Reader, follow bool) error {
+	br := bufio.NewReader(r)
+	var line []byte
+	for {
+		b, err := br.ReadSlice('\n')
+		line = append(line, b...)
+		switch {
+		case err == nil:
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && follow:
+			// Keep a partial line until the rest of it is written.
+			t := time.NewTimer(pollInterval)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return nil
+			case <-t.C:
+			}
+			continue
+		case errors.Is(err, io.EOF):
+			if len(line) == 0 {
+				return nil
+			}
+		default:
+			return err
+		}
+		if err := v.line(line); err != nil {
+			return err
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		line = line[:0]
+	}
+}
+
+// Line renders one line of input.
+func (v *viewer) line(b []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	r, src, ok := decodeRecord(bytes.TrimSpace(b))
+	if !ok {
+		if !bytes.HasSuffix(b, []byte{'\n'}) {
+			b = append(b, '\n')
+		}
+		_, err := v.out.Write(b)
+		return err
+	}
+	ctx := context.Background()
+	if !v.h.Enabled(ctx, r.Level) {
+		return nil
+	}
+	if src != nil {
+		ctx = hooks.WithSource(ctx, src)
+	}
+	return v.h.Handle(ctx, r)
+}
+
+// DecodeRecord converts a JSON object written by zlog or zerolog into a
+// record and its source, reporting false if "b" isn't a JSON object.
+//
+// The level, message, time, and source are recognized by the keys used by
+// either library; everything else becomes an Attr, in the order written.
+func decodeRecord(b []byte) (r slog.Record, src *slog.Source, ok bool) {
+	if len(b) == 0 || b[0] != '{' || !json.Valid(b) {
+		return r, nil, false
+	}
+	r = slog.NewRecord(time.Time{}, slog.LevelInfo, "", 0)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil { // Opening brace.
+		return r, nil, false
+	}
+	for dec.More() {
+		k, v, err := jsonattr.DecodeField(dec)
+		if err != nil {
+			return r, nil, false
+		}
+		s, isString := v.(string)
+		switch k {
+		case "level":
+			if l, ok := parseLevel(s); isString && ok {
+				r.Level = l
+				continue
+			}
+		case "msg", "message":
+			if isString && r.Message == "" {
+				r.Message = s
+				continue
+			}
+		case "time":
+			if t, ok := parseTime(v); ok {
+				r.Time = t
+				continue
+			}
+		case "source", "caller":
+			if isString && src == nil {
+				src = parseSource(s)
+				continue
+			}
+		}
+		r.AddAttrs(jsonattr.ToAttr(k, v))
+	}
+	return r, src, true
+}
+
+// ZerologLevels are the zerolog level names that aren't slog level names.
+var zerologLevels = map[string]slog.Level{
+	"trace": slog.LevelDebug - 4,
+	"fatal": slog.LevelError + 4,
+	"panic": slog.LevelError + 12,
+}
+
+// ParseLevel parses a level name, as written by either slog or zerolog.
+func parseLevel(s string) (slog.Level, bool) {
+	if l, ok := zerologLevels[strings.ToLower(s)]; ok {
+		return l, true
+	}
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	return l, err == nil
+}
+
+// ParseTime parses a timestamp, either in RFC 3339 format or as seconds
+// since the Unix epoch.
+func parseTime(v any) (time.Time, bool) {
+	switch v := v.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		sec, frac := int64(f), f-float64(int64(f))
+		return time.Unix(sec, int64(frac*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+// ParseSource parses a source written as "file:line" or as a function name.
+func parseSource(s string) *slog.Source {
+	if i := strings.LastIndexByte(s, ':'); i != -1 {
+		if n, err := strconv.Atoi(s[i+1:]); err == nil {
+			return &slog.Source{File: s[:i], Line: n}
+		}
+	}
+	return &slog.Source{Function: s}
+}
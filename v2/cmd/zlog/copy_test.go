@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/quay/zlog/v2"
+)
+
+const input = `{"level":"INFO","source":"example.com/pkg.F","time":"2009-11-10T23:00:00Z","msg":"from v2","a":1,"g":{"b":true}}
+not JSON
+{"level":"debug","caller":"/src/pkg/file.go:12","time":"2009-11-10T23:00:01Z","message":"from zerolog","list":[1,2]}
+{"level":"trace","message":"hidden"}
+{"level":"WARN+2","msg":"no time"}
+`
+
+const want = "INFO \x1f example.com/pkg.F\x1f 2009-11-10T23:00:00Z\x1f from v2\x1d a=1\x1f g.b=true\x1f\x1e\n" +
+	"not JSON\n" +
+	"DEBUG\x1f pkg/file.go:12\x1f 2009-11-10T23:00:01Z\x1f from zerolog\x1d list=[1 2]\x1f\x1e\n" +
+	"WARN+2\x1f no time\x1d\x1e\n"
+
+func newViewer(out io.Writer, follow bool) *viewer {
+	return &viewer{
+		out: out,
+		h: zlog.NewHandler(out, &zlog.Options{
+			Level:       slog.LevelDebug,
+			ProseFormat: true,
+			Prose:       &zlog.ProseOptions{Compact: true},
+		}),
+		follow: follow,
+	}
+}
+
+func TestViewer(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newViewer(&buf, false).copy(context.Background(), strings.NewReader(input), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Error(cmp.Diff(got, want))
+	}
+}
+
+func TestFollow(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		v := newViewer(w, true)
+		w.CloseWithError(v.Run(ctx, []string{name}))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		r.Close()
+		<-done
+	})
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			lines <- string(buf[:n])
+		}
+	}()
+
+	// Write a line in two parts, to check that partial lines are held.
+	in := `{"level":"INFO","msg":"appended"}` + "\n"
+	for _, part := range []string{in[:10], in[10:]} {
+		if _, err := f.WriteString(part); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * pollInterval)
+	}
+	select {
+	case got := <-lines:
+		if want := "INFO \x1f appended\x1d\x1e\n"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+}
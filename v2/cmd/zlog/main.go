@@ -0,0 +1,143 @@
+// Command zlog renders newline-delimited JSON logs as prose.
+//
+// It reads records written by the JSON formatter of
+// [github.com/quay/zlog/v2], or by zerolog as used by [github.com/quay/zlog],
+// from the named files or the standard input. The records are written to the
+// standard output with the same prose formatter used by the library, so the
+// colors and the "ZLOG_COLORS" and "ZLOG_THEME" environment variables work
+// the same way. Lines that aren't JSON objects are passed through unchanged.
+//
+// Usage:
+//
+//	zlog [flags] [file...]
+//
+// The flags are:
+//
+//	-level level
+//		Only show records at or above this level, like "info" or "WARN+2".
+//	-f
+//		Keep reading the files as they grow, like "tail -f".
+//	-layout layout
+//		The layout for the prose output. See [zlog.ProseOptions].
+//	-expanded
+//		Put long and multi-line values on continuation lines.
+//
+// For example, to follow the warnings of a deployment:
+//
+//	kubectl logs -f deploy/example | zlog -level warn
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/quay/zlog/v2"
+)
+
+func main() {
+	var (
+		level    = flag.String("level", "", "only show records at or above `level`")
+		follow   = flag.Bool("f", false, "keep reading files as they grow")
+		layout   = flag.String("layout", zlog.DefaultProseLayout, "prose `layout`")
+		expanded = flag.Bool("expanded", false, "put long and multi-line values on continuation lines")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [file...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	opts := zlog.Options{
+		Level:       zlog.LevelEverything,
+		ProseFormat: true,
+		Prose: &zlog.ProseOptions{
+			Layout:   *layout,
+			Expanded: *expanded,
+		},
+	}
+	if *level != "" {
+		l, ok := parseLevel(*level)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "zlog: unknown level %q\n", *level)
+			os.Exit(2)
+		}
+		opts.Level = l
+	}
+	v := &viewer{
+		out:    os.Stdout,
+		h:      zlog.NewHandler(os.Stdout, &opts),
+		follow: *follow,
+	}
+	ctx := context.Background()
+	if *follow {
+		// Stop following on an interrupt, which leaves the default behavior
+		// for a second one in case an input doesn't notice.
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+		context.AfterFunc(ctx, stop)
+	}
+	if err := v.Run(ctx, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "zlog: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Viewer copies log records from its inputs to its output.
+type viewer struct {
+	// Mu serializes writes to "out", which are done directly and through "h".
+	mu     sync.Mutex
+	out    io.Writer
+	h      slog.Handler
+	follow bool
+}
+
+// Run reads the named files, or the standard input if there are none.
+//
+// When following, the files are read concurrently, as they're never finished,
+// until "ctx" is done.
+func (v *viewer) Run(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	if !v.follow {
+		for _, n := range names {
+			if err := v.copyFile(ctx, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, n := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = v.copyFile(ctx, n)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// CopyFile copies the records in the named file, where "-" is the standard
+// input.
+func (v *viewer) copyFile(ctx context.Context, name string) error {
+	if name == "-" {
+		// The standard input is followed by reading it until it's closed.
+		return v.copy(ctx, os.Stdin, false)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return v.copy(ctx, f, v.follow)
+}
@@ -1,7 +1,9 @@
 package zlog
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"runtime/pprof"
@@ -170,3 +172,108 @@ func Example_with_Attrs() {
 	// {"level":"INFO","msg":"with more ctx attrs","contextual":"value","appended":"value"}
 	// {"level":"INFO","msg":"without ctx attrs","a":"b"}
 }
+
+// In this example, a pipeline of middleware is used to enrich, redact, and
+// filter records before they're formatted.
+func Example_middleware() {
+	opts := ExampleOptions
+	opts.Middleware = []Middleware{
+		// Drop health checks entirely.
+		func(_ context.Context, r *RecordView) bool {
+			return r.Message != "health check"
+		},
+		// Redact passwords, including those in groups.
+		func(_ context.Context, r *RecordView) bool {
+			r.Attrs = redact(r.Attrs)
+			return true
+		},
+		// Demote noisy records.
+		func(_ context.Context, r *RecordView) bool {
+			if r.Message == "retrying" {
+				r.Level = slog.LevelDebug
+			}
+			return true
+		},
+	}
+	opts.Level = slog.LevelInfo
+	h := NewHandler(os.Stdout, &opts)
+	log := slog.New(h).WithGroup("g")
+
+	log.Info("health check")
+	log.With("password", "hunter2").Info("login", "user", "alice")
+	log.Warn("retrying")
+
+	// Output:
+	// {"level":"INFO","msg":"login","g":{"password":"REDACTED","user":"alice"}}
+}
+
+// Redact returns "as" with the value of every "password" Attr replaced.
+func redact(as []slog.Attr) []slog.Attr {
+	as = slices.Clone(as)
+	for i, a := range as {
+		switch {
+		case a.Key == "password":
+			as[i].Value = slog.StringValue("REDACTED")
+		case a.Value.Kind() == slog.KindGroup:
+			as[i].Value = slog.GroupValue(redact(a.Value.Group())...)
+		}
+	}
+	return as
+}
+
+// In this example, debug records are held in a flight buffer and only emitted
+// because an error is logged later.
+func ExampleWithFlightBuffer() {
+	h := NewHandler(os.Stdout, &Options{
+		OmitTime:   true,
+		OmitSource: true,
+	})
+	log := slog.New(h)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Successful request:
+	{
+		ctx := WithFlightBuffer(ctx)
+		log.DebugContext(ctx, "step", "n", 1)
+		log.InfoContext(ctx, "ok")
+	}
+	// Failed request:
+	{
+		ctx := WithFlightBuffer(ctx)
+		log.DebugContext(ctx, "step", "n", 1)
+		log.DebugContext(ctx, "step", "n", 2)
+		log.ErrorContext(ctx, "failed")
+	}
+	cancel()
+
+	// Output:
+	// {"level":"INFO","msg":"ok"}
+	// {"level":"DEBUG","msg":"step","n":1}
+	// {"level":"DEBUG","msg":"step","n":2}
+	// {"level":"ERROR","msg":"failed"}
+}
+
+// In this example, prose output is decoded back into records.
+func ExampleProseDecoder() {
+	var buf bytes.Buffer
+	log := slog.New(NewHandler(&buf, &Options{
+		OmitTime:    true,
+		OmitSource:  true,
+		ProseFormat: true,
+	}))
+	log.Info("request", "status", 200, slog.Group("http", "method", "GET"))
+	log.Warn("slow", "took", 1.5)
+
+	dec := NewProseDecoder(&buf)
+	for {
+		rec, err := dec.Decode()
+		if err != nil {
+			break
+		}
+		fmt.Println(rec.Level, rec.Message, rec.Attrs)
+	}
+
+	// Output:
+	// INFO request [status=200 http=[method=GET]]
+	// WARN slow [took=1.5]
+}
@@ -0,0 +1,304 @@
+package zlog
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FailoverKey is the key of the Group attached to records that were handled by
+// a fallback handler in a chain created by [NewFailoverHandler].
+//
+// The Group contains the index of the handler that accepted the record as
+// "handler" and the reason the preceding handler was bypassed as "error".
+const FailoverKey = "failover"
+
+// ErrHandlerTimeout is reported when a handler in a failover chain does not
+// return within the configured [FailoverOptions.Timeout].
+var ErrHandlerTimeout = errors.New("zlog: handler timed out")
+
+// FailoverOptions is used to configure the [slog.Handler] returned by
+// [NewFailoverHandler].
+type FailoverOptions struct {
+	// Timeout is the longest a handler is allowed to take before the record is
+	// sent to the next handler in the chain. The zero value means no timeout.
+	//
+	// A handler that times out is not interrupted; the record may end up being
+	// emitted by both handlers.
+	//
+	// With a timeout, every record is handled on a new goroutine. While a
+	// call that timed out hasn't returned, records skip that handler as if
+	// they'd timed out too, so a hung handler ties up about one goroutine
+	// rather than one per record.
+	Timeout time.Duration
+	// Threshold is the number of consecutive failures needed to trip the
+	// circuit breaker for a handler. Values less than 1 are treated as 1.
+	Threshold int
+	// Cooldown is how long a tripped handler is skipped before a record is
+	// sent to it again. If the record is handled successfully, the handler is
+	// considered recovered. The zero value means one second.
+	Cooldown time.Duration
+}
+
+// NewFailoverHandler returns an [slog.Handler] that sends every record to the
+// first available handler in "hs", falling back to later handlers if a handler
+// reports an error or times out. Handlers that aren't enabled for the level of
+// a record are passed over, so a later handler may accept records below the
+// level of the first.
+//
+// Each handler formats the record itself, so a chain may mix formats and
+// destinations; for example, the journal, then JSON on [os.Stderr], then a
+// [RingBuffer]. Records that end up handled by a fallback are marked with a
+// Group at [FailoverKey].
+//
+// A handler that fails repeatedly is skipped until its cooldown elapses, as
+// configured by "opts". The last handler in the chain is never skipped.
+// If "nil" is passed for options, suitable defaults will be used.
+func NewFailoverHandler(opts *FailoverOptions, hs ...slog.Handler) slog.Handler {
+	if len(hs) == 0 {
+		panic("programmer error: no handlers provided")
+	}
+	if opts == nil {
+		opts = &FailoverOptions{}
+	}
+	f := &failover{
+		opts:     *opts,
+		hs:       hs,
+		breakers: make([]breaker, len(hs)),
+	}
+	if f.opts.Threshold < 1 {
+		f.opts.Threshold = 1
+	}
+	if f.opts.Cooldown <= 0 {
+		f.opts.Cooldown = time.Second
+	}
+	return f
+}
+
+// Failover is the concrete type for the handlers returned by
+// [NewFailoverHandler].
+type failover struct {
+	opts FailoverOptions
+	hs   []slog.Handler
+	// Breakers is shared by all the handlers derived from the same call to
+	// NewFailoverHandler, as the sinks are the same.
+	breakers []breaker
+}
+
+// Enabled implements [slog.Handler].
+func (f *failover) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range f.hs {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle implements [slog.Handler].
+func (f *failover) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	var reason error
+	last := len(f.hs) - 1
+	for i, h := range f.hs {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		b := &f.breakers[i]
+		if i != last && !b.Allow(f.opts.Cooldown) {
+			if reason == nil {
+				reason = errCircuitOpen
+			}
+			continue
+		}
+		rec := r
+		if reason != nil {
+			rec = r.Clone()
+			rec.AddAttrs(slog.Group(FailoverKey,
+				slog.Int("handler", i),
+				slog.String("error", reason.Error()),
+			))
+		}
+		err := f.try(ctx, b, h, rec)
+		if err == nil {
+			b.Success()
+			return nil
+		}
+		b.Failure(f.opts.Threshold, f.opts.Cooldown)
+		errs = append(errs, err)
+		reason = err
+	}
+	return errors.Join(errs...)
+}
+
+// Try calls the Handle method of "h", enforcing the configured timeout and
+// tracking calls that outlive it in "b".
+func (f *failover) try(ctx context.Context, b *breaker, h slog.Handler, r slog.Record) error {
+	if f.opts.Timeout <= 0 {
+		return h.Handle(ctx, r)
+	}
+	if b.stuck.Load() != 0 {
+		return ErrHandlerTimeout
+	}
+	const (
+		running = iota
+		done
+		abandoned
+	)
+	var state atomic.Int32
+	ch := make(chan error, 1)
+	r = r.Clone()
+	go func() {
+		ch <- h.Handle(ctx, r)
+		if !state.CompareAndSwap(running, done) {
+			b.stuck.Add(-1)
+		}
+	}()
+	t := time.NewTimer(f.opts.Timeout)
+	defer t.Stop()
+	select {
+	case err := <-ch:
+		return err
+	case <-t.C:
+		if state.CompareAndSwap(running, abandoned) {
+			b.stuck.Add(1)
+		}
+		return ErrHandlerTimeout
+	}
+}
+
+// WithAttrs implements [slog.Handler].
+func (f *failover) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make([]slog.Handler, len(f.hs))
+	for i, h := range f.hs {
+		hs[i] = h.WithAttrs(attrs)
+	}
+	return &failover{
+		opts:     f.opts,
+		hs:       hs,
+		breakers: f.breakers,
+	}
+}
+
+// WithGroup implements [slog.Handler].
+func (f *failover) WithGroup(name string) slog.Handler {
+	hs := make([]slog.Handler, len(f.hs))
+	for i, h := range f.hs {
+		hs[i] = h.WithGroup(name)
+	}
+	return &failover{
+		opts:     f.opts,
+		hs:       hs,
+		breakers: f.breakers,
+	}
+}
+
+// ErrCircuitOpen is the reason reported for skipping a handler with a tripped
+// breaker.
+var errCircuitOpen = errors.New("circuit open")
+
+// Breaker is a circuit breaker for a single handler in a failover chain.
+type breaker struct {
+	mu sync.Mutex
+	// Fails is the count of consecutive failures.
+	fails int
+	// Until is when the breaker may be tried again. The zero value means the
+	// breaker is closed.
+	until time.Time
+	// Probing reports whether a record has been let through after the
+	// cooldown and not yet reported back.
+	probing bool
+	// Stuck is the count of calls that timed out and haven't returned.
+	stuck atomic.Int32
+}
+
+// Allow reports whether a record should be sent to the handler.
+//
+// Once the cooldown has elapsed, a single record is let through to probe the
+// handler; others are turned away until it's reported on.
+func (b *breaker) Allow(cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch now := time.Now(); {
+	case b.until.IsZero():
+		return true
+	case b.probing && now.Before(b.until.Add(cooldown)):
+		return false
+	case now.Before(b.until):
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Success records that the handler succeeded, closing the breaker.
+func (b *breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fails = 0
+	b.until = time.Time{}
+	b.probing = false
+}
+
+// Failure records that the handler failed, tripping the breaker if the
+// threshold has been reached.
+func (b *breaker) Failure(threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fails++
+	b.probing = false
+	if b.fails >= threshold {
+		b.until = time.Now().Add(cooldown)
+	}
+}
+
+// RingBuffer is an [io.Writer] that retains the contents of the most recent
+// writes, suitable for use as the last resort in a failover chain.
+//
+// Every call to Write is considered one record.
+type RingBuffer struct {
+	mu   sync.Mutex
+	recs [][]byte
+	next int
+	full bool
+}
+
+// NewRingBuffer returns a [RingBuffer] retaining "n" records.
+func NewRingBuffer(n int) *RingBuffer {
+	if n < 1 {
+		panic("programmer error: bad RingBuffer size")
+	}
+	return &RingBuffer{recs: make([][]byte, n)}
+}
+
+// Write implements [io.Writer].
+func (r *RingBuffer) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recs[r.next] = append(r.recs[r.next][:0], b...)
+	r.next++
+	if r.next == len(r.recs) {
+		r.next = 0
+		r.full = true
+	}
+	return len(b), nil
+}
+
+// Records returns copies of the retained records, oldest first.
+func (r *RingBuffer) Records() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out [][]byte
+	if r.full {
+		for _, b := range r.recs[r.next:] {
+			out = append(out, append([]byte(nil), b...))
+		}
+	}
+	for _, b := range r.recs[:r.next] {
+		out = append(out, append([]byte(nil), b...))
+	}
+	return out
+}
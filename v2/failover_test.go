@@ -0,0 +1,185 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// FailWriter is an io.Writer that fails while "fail" is set.
+type failWriter struct {
+	fail atomic.Bool
+	buf  bytes.Buffer
+}
+
+func (w *failWriter) Write(b []byte) (int, error) {
+	if w.fail.Load() {
+		return 0, errors.New("write failed")
+	}
+	return w.buf.Write(b)
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	opts := &Options{OmitTime: true, OmitSource: true}
+	var primary failWriter
+	ring := NewRingBuffer(2)
+	h := NewFailoverHandler(&FailoverOptions{
+		Threshold: 2,
+		Cooldown:  10 * time.Millisecond,
+	}, NewHandler(&primary, opts), NewHandler(ring, opts))
+	log := slog.New(h).With("a", "b")
+
+	log.InfoContext(ctx, "one")
+	if got := primary.buf.Len(); got == 0 {
+		t.Error("expected write to primary")
+	}
+	if got := ring.Records(); len(got) != 0 {
+		t.Errorf("unexpected writes to fallback: %q", got)
+	}
+
+	primary.fail.Store(true)
+	log.InfoContext(ctx, "two")
+	log.InfoContext(ctx, "three") // Trips the breaker.
+	log.InfoContext(ctx, "four")  // Skips the primary entirely.
+	recs := ring.Records()
+	if got, want := len(recs), 2; got != want {
+		t.Fatalf("got: %d records, want: %d", got, want)
+	}
+	for i, want := range []string{"write failed", errCircuitOpen.Error()} {
+		var v struct {
+			A        string
+			Failover struct {
+				Handler int
+				Error   string
+			}
+		}
+		if err := json.Unmarshal(recs[i], &v); err != nil {
+			t.Fatal(err)
+		}
+		if got := v.Failover.Error; got != want {
+			t.Errorf("record %d: got: %q, want: %q", i, got, want)
+		}
+		if got, want := v.Failover.Handler, 1; got != want {
+			t.Errorf("record %d: got: %d, want: %d", i, got, want)
+		}
+		if got, want := v.A, "b"; got != want {
+			t.Errorf("record %d: got: %q, want: %q", i, got, want)
+		}
+	}
+
+	primary.fail.Store(false)
+	time.Sleep(20 * time.Millisecond)
+	primary.buf.Reset()
+	log.InfoContext(ctx, "five")
+	if got := primary.buf.Len(); got == 0 {
+		t.Error("expected primary to recover")
+	}
+}
+
+func TestFailoverProbeDisabled(t *testing.T) {
+	ctx := context.Background()
+	var primary failWriter
+	ring := NewRingBuffer(4)
+	h := NewFailoverHandler(&FailoverOptions{
+		Cooldown: 50 * time.Millisecond,
+	},
+		NewHandler(&primary, &Options{OmitTime: true, OmitSource: true}),
+		NewHandler(ring, &Options{OmitTime: true, OmitSource: true, Level: slog.LevelDebug}),
+	)
+	log := slog.New(h)
+
+	primary.fail.Store(true)
+	log.InfoContext(ctx, "one") // Trips the breaker.
+	primary.fail.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	// Below the primary's level, so the probe isn't used.
+	log.DebugContext(ctx, "two")
+	primary.buf.Reset()
+	log.InfoContext(ctx, "three")
+	if got := primary.buf.Len(); got == 0 {
+		t.Error("expected primary to be probed")
+	}
+}
+
+// SlowHandler is an slog.Handler that takes too long.
+type slowHandler struct{ slog.Handler }
+
+func (h slowHandler) Handle(ctx context.Context, r slog.Record) error {
+	time.Sleep(50 * time.Millisecond)
+	return h.Handler.Handle(ctx, r)
+}
+
+func TestFailoverTimeout(t *testing.T) {
+	ctx := context.Background()
+	opts := &Options{OmitTime: true, OmitSource: true}
+	var slow, fast bytes.Buffer
+	h := NewFailoverHandler(&FailoverOptions{
+		Timeout: time.Millisecond,
+	}, slowHandler{NewHandler(&slow, opts)}, NewHandler(&fast, opts))
+	slog.New(h).InfoContext(ctx, "test")
+	want := `{"level":"INFO","msg":"test","failover":{"handler":1,"error":"zlog: handler timed out"}}` + "\n"
+	if got := fast.String(); got != want {
+		t.Errorf("got: %#q, want: %#q", got, want)
+	}
+}
+
+func TestFailoverLevels(t *testing.T) {
+	ctx := context.Background()
+	var primary, secondary bytes.Buffer
+	h := NewFailoverHandler(nil,
+		NewHandler(&primary, &Options{OmitTime: true, OmitSource: true, Level: slog.LevelWarn}),
+		NewHandler(&secondary, &Options{OmitTime: true, OmitSource: true, Level: slog.LevelDebug}),
+	)
+	log := slog.New(h)
+	log.DebugContext(ctx, "debug")
+	log.WarnContext(ctx, "warn")
+	if got, want := primary.String(), `{"level":"WARN","msg":"warn"}`+"\n"; got != want {
+		t.Errorf("got: %#q, want: %#q", got, want)
+	}
+	// Not a failover, so no Group is added.
+	if got, want := secondary.String(), `{"level":"DEBUG","msg":"debug"}`+"\n"; got != want {
+		t.Errorf("got: %#q, want: %#q", got, want)
+	}
+}
+
+// HungHandler is an slog.Handler that blocks until "release" is closed.
+type hungHandler struct {
+	slog.Handler
+	calls   atomic.Int64
+	release chan struct{}
+}
+
+func (h *hungHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.calls.Add(1)
+	<-h.release
+	return nil
+}
+
+func TestFailoverTimeoutHung(t *testing.T) {
+	ctx := context.Background()
+	opts := &Options{OmitTime: true, OmitSource: true}
+	hung := &hungHandler{Handler: NewHandler(io.Discard, opts), release: make(chan struct{})}
+	defer close(hung.release)
+	ring := NewRingBuffer(8)
+	h := NewFailoverHandler(&FailoverOptions{
+		Timeout:   time.Millisecond,
+		Threshold: 100,
+	}, hung, NewHandler(ring, opts))
+	log := slog.New(h)
+	for i := 0; i < 5; i++ {
+		log.InfoContext(ctx, "test")
+	}
+	if got, want := hung.calls.Load(), int64(1); got != want {
+		t.Errorf("got: %d calls, want: %d", got, want)
+	}
+	if got, want := len(ring.Records()), 5; got != want {
+		t.Errorf("got: %d records, want: %d", got, want)
+	}
+}
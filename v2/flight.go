@@ -0,0 +1,135 @@
+package zlog
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/quay/zlog/v2/internal/hooks"
+)
+
+// FlightBufferSize is the number of records held by a flight buffer. Once
+// full, the oldest records are discarded.
+const FlightBufferSize = 256
+
+// WithFlightBuffer returns a Context that holds on to records that would
+// otherwise be discarded for being below the configured level.
+//
+// Records logged with the returned Context (or one derived from it) that are
+// not enabled but are at least [Options.FlightLevel] are kept in memory. If a
+// record at [slog.LevelError] or above is logged with the Context, the held
+// records are emitted in order, with their original timestamps, before the
+// triggering record. They can also be emitted explicitly with
+// [FlushFlightBuffer]. Held records are discarded once the passed Context is
+// done.
+//
+// This allows a request to log a detailed trail that's only emitted if the
+// request fails.
+func WithFlightBuffer(ctx context.Context) context.Context {
+	fb := &flightBuffer{
+		recs: make([]flightRecord, 0, FlightBufferSize),
+	}
+	context.AfterFunc(ctx, fb.Discard)
+	return context.WithValue(ctx, flightKey{}, fb)
+}
+
+// FlushFlightBuffer emits all records held in the flight buffer associated
+// with the Context, if any.
+func FlushFlightBuffer(ctx context.Context) {
+	if fb := flightFromContext(ctx); fb != nil {
+		fb.Flush()
+	}
+}
+
+// FlightKey is the [context.Context] key for a [flightBuffer].
+type flightKey struct{}
+
+// FlightFromContext returns the flight buffer in the Context, if present.
+func flightFromContext(ctx context.Context) *flightBuffer {
+	fb, _ := ctx.Value(flightKey{}).(*flightBuffer)
+	return fb
+}
+
+// FlightEnabled reports whether a record at level "l" should be handled so
+// that it can be held in a flight buffer.
+func (h *handler[S]) flightEnabled(ctx context.Context, l slog.Level) bool {
+	if flightFromContext(ctx) == nil {
+		return false
+	}
+	min := slog.LevelDebug
+	if h.opts.FlightLevel != nil {
+		min = h.opts.FlightLevel.Level()
+	}
+	return l >= min
+}
+
+// FlightBuffer is a ring buffer of records.
+type flightBuffer struct {
+	mu   sync.Mutex
+	recs []flightRecord
+	// Next is the index of the oldest record, once the buffer is full.
+	next int
+	done bool
+}
+
+// FlightRecord is a record held in a flight buffer, along with the information
+// needed to emit it later.
+type flightRecord struct {
+	h   emitter
+	ctx context.Context
+	r   slog.Record
+}
+
+// Emitter is implemented by the handlers that can emit a held record.
+type emitter interface {
+	emit(context.Context, slog.Record) error
+}
+
+func init() {
+	hooks.Emit = func(h slog.Handler, ctx context.Context, r slog.Record) error {
+		return h.(emitter).emit(ctx, r)
+	}
+}
+
+// Add holds the record "r", to be emitted via "h".
+func (fb *flightBuffer) Add(h emitter, ctx context.Context, r slog.Record) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if fb.done {
+		return
+	}
+	rec := flightRecord{h: h, ctx: ctx, r: r.Clone()}
+	if len(fb.recs) < cap(fb.recs) {
+		fb.recs = append(fb.recs, rec)
+		return
+	}
+	fb.recs[fb.next] = rec
+	fb.next = (fb.next + 1) % len(fb.recs)
+}
+
+// Flush emits and clears all the held records.
+func (fb *flightBuffer) Flush() {
+	fb.mu.Lock()
+	recs := make([]flightRecord, 0, len(fb.recs))
+	recs = append(recs, fb.recs[fb.next:]...)
+	recs = append(recs, fb.recs[:fb.next]...)
+	clear(fb.recs)
+	fb.recs = fb.recs[:0]
+	fb.next = 0
+	fb.mu.Unlock()
+
+	for _, rec := range recs {
+		rec.h.emit(rec.ctx, rec.r)
+	}
+}
+
+// Discard clears all the held records and causes any further records to be
+// ignored.
+func (fb *flightBuffer) Discard() {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	clear(fb.recs)
+	fb.recs = nil
+	fb.next = 0
+	fb.done = true
+}
@@ -0,0 +1,74 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestFlightBuffer(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(NewHandler(&buf, &Options{OmitSource: true}))
+
+	t.Run("Flush", func(t *testing.T) {
+		buf.Reset()
+		ctx := WithFlightBuffer(context.Background())
+		r := slog.NewRecord(time.Unix(0, 0), slog.LevelDebug, "held", 0)
+		if err := log.Handler().Handle(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("unexpected output: %q", buf.String())
+		}
+		FlushFlightBuffer(ctx)
+		want := `{"level":"DEBUG","time":"1970-01-01T00:00:00Z","msg":"held"}` + "\n"
+		if got := buf.String(); got != want {
+			t.Errorf("got: %#q, want: %#q", got, want)
+		}
+		buf.Reset()
+		FlushFlightBuffer(ctx)
+		if buf.Len() != 0 {
+			t.Errorf("unexpected output: %q", buf.String())
+		}
+	})
+
+	t.Run("Discard", func(t *testing.T) {
+		buf.Reset()
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx = WithFlightBuffer(ctx)
+		log.DebugContext(ctx, "held")
+		cancel()
+		// AfterFunc runs in its own goroutine.
+		fb := flightFromContext(ctx)
+		for i := 0; i < 100; i++ {
+			fb.mu.Lock()
+			done := fb.done
+			fb.mu.Unlock()
+			if done {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		log.ErrorContext(ctx, "failed")
+		if got, want := bytes.Count(buf.Bytes(), []byte("\n")), 1; got != want {
+			t.Errorf("got: %d records, want: %d:\n%s", got, want, buf.String())
+		}
+	})
+
+	t.Run("Overflow", func(t *testing.T) {
+		buf.Reset()
+		ctx := WithFlightBuffer(context.Background())
+		for i := 0; i < FlightBufferSize+10; i++ {
+			log.DebugContext(ctx, "held", "i", i)
+		}
+		FlushFlightBuffer(ctx)
+		if got, want := bytes.Count(buf.Bytes(), []byte("\n")), FlightBufferSize; got != want {
+			t.Errorf("got: %d records, want: %d", got, want)
+		}
+		if !bytes.HasSuffix(bytes.SplitN(buf.Bytes(), []byte("\n"), 2)[0], []byte(`"msg":"held","i":10}`)) {
+			t.Errorf("unexpected first record: %q", bytes.SplitN(buf.Bytes(), []byte("\n"), 2)[0])
+		}
+	})
+}
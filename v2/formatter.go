@@ -40,6 +40,14 @@ type formatter[S state] struct {
 	// Grouping hooks:
 	PushGroup func(*buffer, S, string)
 	PopGroup  func(*buffer, S)
+
+	// WriteContextGroup, if set, is called to write one of the contextual
+	// groups (the baggage or pprof labels) instead of the grouping and
+	// writing hooks.
+	WriteContextGroup func(*buffer, S, string, [][2]string)
+	// EndAttrs, if set, is called after the Attrs passed to WithAttrs are
+	// written to the preformatted buffer.
+	EndAttrs func(*buffer, S)
 }
 
 // State is an object that's used per-record to keep track of formatting.
@@ -1,7 +1,6 @@
 package zlog
 
 import (
-	"bytes"
 	"encoding"
 	"encoding/binary"
 	"encoding/json"
@@ -32,7 +31,7 @@ var formatterJournal = formatter[*stateJournal]{
 			*b = strconv.AppendInt(*b, int64(f.Line), 10)
 			b.WriteByte('\n')
 		}
-		if f.Func != nil {
+		if f.Function != "" {
 			b.WriteString(`CODE_FUNC=`)
 			journalString(b, f.Function)
 		}
@@ -137,15 +136,7 @@ var formatterJournal = formatter[*stateJournal]{
 	},
 
 	PushGroup: func(b *buffer, s *stateJournal, g string) { s.pushGroup(g) },
-	PopGroup: func(b *buffer, s *stateJournal) {
-		s.groups = s.groups[:len(s.groups)-1]
-		i := bytes.LastIndexByte(s.prefix, '.')
-		i--
-		if i < 0 {
-			i = 0
-		}
-		s.prefix = s.prefix[:i]
-	},
+	PopGroup:  func(b *buffer, s *stateJournal) { s.popGroup() },
 }
 
 // JournalString is a helper to emit the correct encoding for a journal value.
@@ -206,6 +197,17 @@ func (s *stateJournal) Reset(g []string, prefmt *buffer) {
 	}
 }
 
+// PopGroup removes the last group from the formatter state.
+func (s *stateJournal) popGroup() {
+	g := s.groups[len(s.groups)-1]
+	s.groups = s.groups[:len(s.groups)-1]
+	n := len(s.prefix) - len(g)
+	if n > 0 {
+		n-- // Remove the separator.
+	}
+	s.prefix = s.prefix[:n]
+}
+
 // PushGroup adds a group to the formatter state.
 func (s *stateJournal) pushGroup(g string) {
 	s.groups = append(s.groups, g)
@@ -1,7 +1,6 @@
 package zlog
 
 import (
-	"bytes"
 	"encoding"
 	"encoding/base64"
 	"encoding/json"
@@ -23,40 +22,11 @@ var formatterJSON = formatter[*stateJSON]{
 		b.WriteByte('{')
 	},
 	End: func(b *buffer, s *stateJSON, n int) {
-		if n == 0 {
-			// If there are no attrs, the handler needs to make sure no empty
-			// groups are written.
-			for len(*b) > 0 {
-				if b.Tail() != '{' {
-					break
-				}
-				i := bytes.LastIndexByte(*b, ',')
-				if i == -1 {
-					i = 0
-				}
-				*b = (*b)[:i]
-				s.groups--
-			}
-		}
 		if b.Tail() == ',' {
 			b.ReplaceTail('}')
 		} else {
 			b.WriteByte('}')
 		}
-		if s.groups != 0 {
-			var open int
-			for _, v := range *b {
-				switch v {
-				case '{':
-					open++
-				case '}':
-					open--
-				}
-			}
-			for i := open; i > 0; i-- {
-				b.WriteByte('}')
-			}
-		}
 		b.WriteByte('\n')
 	},
 
@@ -66,6 +36,8 @@ var formatterJSON = formatter[*stateJSON]{
 		b.WriteString(`":"`)
 		if fn := f.Func; fn != nil {
 			writeJSONString(b, fn.Name())
+		} else if f.File == "" {
+			writeJSONString(b, f.Function)
 		} else {
 			writeJSONString(b, f.File)
 			b.WriteByte(':')
@@ -183,13 +155,11 @@ var formatterJSON = formatter[*stateJSON]{
 	},
 
 	PushGroup: func(b *buffer, s *stateJSON, g string) {
-		s.groups++
 		b.WriteByte('"')
 		writeJSONString(b, g)
 		b.WriteString(`":{`)
 	},
 	PopGroup: func(b *buffer, s *stateJSON) {
-		s.groups--
 		if b.Tail() == ',' {
 			b.ReplaceTail('}')
 		} else {
@@ -201,13 +171,11 @@ var formatterJSON = formatter[*stateJSON]{
 
 // StateJSON is the state needed to construct a JSON log record.
 type stateJSON struct {
-	groups    int
 	wroteAttr bool
 }
 
 // Reset implements state.
-func (s *stateJSON) Reset(g []string, _ *buffer) {
-	s.groups = len(g)
+func (s *stateJSON) Reset(_ []string, _ *buffer) {
 	s.wroteAttr = false
 }
 
@@ -14,53 +14,93 @@
 // # Prose output
 //
 // If ProseFormat is set, output will be in prose rather than JSON.
-// The field order is not configurable.
+// The fields of a line are controlled by a layout (see [ProseOptions]), which is
+// text with fields written as "{name}" or "{name:width}". The fields are
+// "level", "source", "time", "delta" (the time since the previous record),
+// "msg", and "attrs", which must be last. Fields
+// may be reordered or left out; the width pads a field, and shortens the
+// source from the left. Literal text next to a field that isn't present for
+// a record, such as the source or time, is left out as well. The default is
+// [DefaultProseLayout].
 // ANSI color codes and [terminal hyperlinks] will be used when attached to a TTY.
+// The color depth (16, 256, or truecolor) and support for hyperlinks are
+// detected from the "TERM", "COLORTERM", and "TERM_PROGRAM" environment
+// variables, and colors are downgraded to fit. Colors are disabled for a
+// "TERM" of "dumb", and hyperlinks for the Linux console, screen, and tmux
+// before version 3.4.
 // The environment variables "[NO_COLOR]" and "ZLOG_COLORS" can be used to
-// control colors.
+// control colors. Setting "CLICOLOR_FORCE" to a value other than "0", or
+// "[FORCE_COLOR]" to a value other than "0" or "false", enables colors even
+// when not attached to a TTY; a "FORCE_COLOR" of "2" or "3" also forces 256
+// colors or truecolor. Similarly, "FORCE_HYPERLINK" enables or disables
+// hyperlinks.
+// When attached to a TTY, the attributes are also aligned into a column and
+// lines are wrapped to the width of the terminal, unless
+// [ProseOptions.Compact] is set.
 // Log records are separated by a ␞, fields are separated by a ␟, and the
 // attributes are separated from the message with a ␝. These [field separators]
-// may trip up incorrect programs.
+// may trip up incorrect programs. Prose output can be turned back into
+// records with a [ProseDecoder].
+// Control characters in messages, keys, and values are shown as visible
+// symbols (like "␛" for ESC), so that log records can't inject escape
+// sequences into a terminal or be confused with the separators.
 //
 // # ZLOG_COLORS
 //
 // The "ZLOG_COLORS" environment variable is akin to "[LS_COLORS]".
-// It is a colon-delimited series of [SGR] parameters.
-// Any characters outside of the range [0-;] will be ignored.
-// The controllable colors are, in order:
+// It is a colon-delimited series of "key=value" pairs, where the value is a
+// series of [SGR] parameters separated by ";". A parameter may also be a
+// "#rrggbb" truecolor value, and 256-color values can be written as usual
+// ("38;5;n"). For example:
 //
-//   - Error Level
-//   - Warn Level
-//   - Info Level
-//   - Debug Level
-//   - Source
-//   - Timestamp
-//   - Message
-//   - Key
-//   - string / [fmt.Stringer]
-//   - bool (true)
-//   - bool (false)
-//   - Number (int64/uint64/float64)
-//   - [time.Time]
-//   - [time.Duration]
-//   - error
-//   - [encoding.TextUnmarshaler]
-//   - [fmt.GoStringer]
-//   - [encoding.BinaryUnmarshaler] / []byte
-//   - [json.Unmarshaler]
-//   - [fmt.Print]
+//	ZLOG_COLORS='level.error=1;31:key=36:url=4:msg=#fdf6e3'
+//	export ZLOG_COLORS
+//
+// Keys that are not mentioned keep their color from the theme, and an empty
+// value removes the color. The keys are:
+//
+//   - level.error: Error Level
+//   - level.warn: Warn Level
+//   - level.info: Info Level
+//   - level.debug: Debug Level
+//   - source: Source
+//   - timestamp: Timestamp
+//   - msg: Message
+//   - key: Key
+//   - string: string / [fmt.Stringer]
+//   - true: bool (true)
+//   - false: bool (false)
+//   - number: Number (int64/uint64/float64)
+//   - time: [time.Time]
+//   - duration: [time.Duration]
+//   - error: error
+//   - text: [encoding.TextUnmarshaler]
+//   - gostring: [fmt.GoStringer]
+//   - binary: [encoding.BinaryUnmarshaler] / []byte
+//   - json: [json.Unmarshaler]
+//   - reflect: [fmt.Print]
+//   - url: [net/url.URL]
+//   - correlation: values of [ProseOptions.CorrelationKeys], in addition to
+//     the color derived from the value
 //
-// All left-ward elements must be present, but may be empty. For example, to highlight
-// only errors:
+// The older positional syntax, a colon-delimited series of SGR parameters in
+// the order above, is also accepted. In that syntax, any characters outside of
+// the range [0-;] will be ignored, and all left-ward elements must be present,
+// but may be empty. For example, to highlight only errors:
 //
 //	ZLOG_COLORS='::::::::::::::5';
 //	export ZLOG_COLORS
 //
+// The "ZLOG_THEME" environment variable selects a built-in theme that
+// "ZLOG_COLORS" is applied on top of: "dark" (the default), "light", or
+// "solarized". A value in the positional syntax replaces the theme entirely.
+//
 // See [DefaultProseColors] for the default colors.
 //
 // [native Journald protocol]: https://systemd.io/JOURNAL_NATIVE_PROTOCOL/
 // [terminal hyperlinks]: https://gist.github.com/egmontkob/eb114294efbcd5adb1944c9f3cb5feda
 // [NO_COLOR]: https://no-color.org/
+// [FORCE_COLOR]: https://force-color.org/
 // [LS_COLORS]: https://www.gnu.org/software/coreutils/manual/coreutils.html#dircolors-invocation
 // [field separators]: https://en.wikipedia.org/wiki/C0_and_C1_control_codes#Field_separators
 // [SGR]: https://en.wikipedia.org/wiki/ANSI_escape_code#SGR_(Select_Graphic_Rendition)_parameters
@@ -73,9 +113,12 @@ import (
 	"log/slog"
 	"runtime"
 	"runtime/pprof"
+	"slices"
 
 	"go.opentelemetry.io/otel/baggage"
 	"go.opentelemetry.io/otel/trace"
+
+	"github.com/quay/zlog/v2/internal/hooks"
 )
 
 // Some extra [slog.Level] aliases and syslog(3) compatible levels (as
@@ -115,7 +158,16 @@ type handler[S state] struct {
 	pool *statePool[S]
 
 	prefmt *buffer
+	// Groups are the groups opened in prefmt.
 	groups []string
+	// Pending are the groups added with WithGroup that haven't been opened
+	// yet. Groups are only opened once an Attr is written to them, so that
+	// empty groups are omitted.
+	pending []string
+	// Scope holds the calls to WithAttrs and WithGroup instead of prefmt and
+	// the groups when there's middleware. The middleware sees the Attrs they
+	// add, and they're then emitted as part of the record.
+	scope *scope
 }
 
 // NewHandler returns an [slog.Handler] emitting records to "w", according to the
@@ -169,6 +221,10 @@ type Options struct {
 	//
 	// When connected to the Journal, this setting has no effect.
 	ProseFormat bool
+	// Prose configures the prose output, if ProseFormat is set.
+	//
+	// If nil, the defaults are used. See [ProseOptions].
+	Prose *ProseOptions
 	// ContextKey is a value to be used with [context.Context.Value] to retrieve a
 	// [slog.Value] Group.
 	//
@@ -181,9 +237,19 @@ type Options struct {
 	// Setting this to a value that results in retrieving any other type will
 	// panic the program.
 	LevelKey any
+	// Middleware is a series of stages that every record is passed through
+	// before being formatted. See [Middleware] for details.
+	Middleware []Middleware
+	// FlightLevel is the minimum level that a log message must have to be
+	// held in a flight buffer. See [WithFlightBuffer].
+	//
+	// If unset, [slog.LevelDebug] is used.
+	FlightLevel slog.Leveler
 
 	// ForceANSI is a hook for testing to force ANSI color output.
 	forceANSI bool
+	// ForceWidth is a hook for testing to force a terminal width.
+	forceWidth int
 }
 
 // NewOptions returns a default [Options] value.
@@ -199,6 +265,15 @@ func NewOptions() *Options {
 
 // Enabled implements [slog.Handler].
 func (h *handler[S]) Enabled(ctx context.Context, l slog.Level) bool {
+	if l >= h.minLevel(ctx) {
+		return true
+	}
+	return h.flightEnabled(ctx, l)
+}
+
+// MinLevel reports the minimum level for records to be emitted, taking any
+// per-record level into account.
+func (h *handler[S]) minLevel(ctx context.Context) slog.Level {
 	min := slog.LevelInfo
 	if h.opts.Level != nil {
 		min = h.opts.Level.Level()
@@ -208,14 +283,37 @@ func (h *handler[S]) Enabled(ctx context.Context, l slog.Level) bool {
 			min = cl.Level()
 		}
 	}
-	return l >= min
+	return min
 }
 
 // Handle implements [slog.Handler].
-func (h *handler[S]) Handle(ctx context.Context, r slog.Record) (err error) {
+func (h *handler[S]) Handle(ctx context.Context, r slog.Record) error {
+	if len(h.opts.Middleware) != 0 {
+		var ok bool
+		r, ok = h.runMiddleware(ctx, r)
+		if !ok {
+			return nil
+		}
+	}
+	if fb := flightFromContext(ctx); fb != nil {
+		if r.Level < h.minLevel(ctx) {
+			fb.Add(h, ctx, r)
+			return nil
+		}
+		if r.Level >= slog.LevelError {
+			fb.Flush()
+		}
+	}
+	return h.emit(ctx, r)
+}
+
+// Emit formats the record and writes it out.
+func (h *handler[S]) emit(ctx context.Context, r slog.Record) (err error) {
 	b := newBuffer()
 	defer b.Release()
-	s := h.pool.Get(h.groups, h.prefmt)
+	// The contextual values are written at the top level, before the groups
+	// are opened.
+	s := h.pool.Get(nil, nil)
 	defer h.pool.Put(s)
 	h.fmt.Start(b, s)
 
@@ -223,10 +321,17 @@ func (h *handler[S]) Handle(ctx context.Context, r slog.Record) (err error) {
 	// Level
 	h.fmt.WriteLevel(b, s, r.Level)
 	// "source"
-	if !h.opts.OmitSource && r.PC != 0 {
+	switch {
+	case h.opts.OmitSource:
+	case r.PC != 0:
 		frames := runtime.CallersFrames([]uintptr{r.PC})
 		frame, _ := frames.Next()
 		h.fmt.WriteSource(b, s, &frame)
+	default:
+		if src := hooks.Source(ctx); src != nil {
+			frame := runtime.Frame{Function: src.Function, File: src.File, Line: src.Line}
+			h.fmt.WriteSource(b, s, &frame)
+		}
 	}
 	// Time, if emitting
 	if !h.opts.OmitTime && !r.Time.IsZero() {
@@ -253,22 +358,13 @@ func (h *handler[S]) Handle(ctx context.Context, r slog.Record) (err error) {
 
 	// Add baggage if filter function is present.
 	if f := h.opts.Baggage; f != nil {
-		g := false
-		bg := baggage.FromContext(ctx)
-		for _, m := range bg.Members() {
-			if !f(m.Key()) {
-				continue
+		var kvs [][2]string
+		for _, m := range baggage.FromContext(ctx).Members() {
+			if f(m.Key()) {
+				kvs = append(kvs, [2]string{m.Key(), m.Value()})
 			}
-			if !g {
-				h.fmt.PushGroup(b, s, h.fmt.BaggageKey)
-				g = true
-			}
-			h.fmt.AppendKey(b, s, m.Key())
-			h.fmt.AppendString(b, s, m.Value())
-		}
-		if g {
-			h.fmt.PopGroup(b, s)
 		}
+		h.writeContextGroup(b, s, h.fmt.BaggageKey, kvs)
 	}
 	// Add pprof labels if present.
 	ls := make([][2]string, 0, 10) // Guess at capacity.
@@ -276,35 +372,45 @@ func (h *handler[S]) Handle(ctx context.Context, r slog.Record) (err error) {
 		ls = append(ls, [2]string{k, v})
 		return true
 	})
-	if len(ls) != 0 {
-		h.fmt.PushGroup(b, s, h.fmt.PprofKey)
-		for _, l := range ls {
-			h.fmt.AppendKey(b, s, l[0])
-			h.fmt.AppendString(b, s, l[1])
-		}
-		h.fmt.PopGroup(b, s)
-	}
+	h.writeContextGroup(b, s, h.fmt.PprofKey, ls)
 
 	// Add the attached Attrs.
+	s.Reset(h.groups, h.prefmt)
 	if h.prefmt != nil {
 		b.Write(*h.prefmt)
 	}
-	if h.opts.ContextKey != nil {
+	var pend []string
+	if len(h.pending) != 0 {
+		pend = slices.Clone(h.pending)
+	}
+	// The middleware has already added the Context's Attrs to the record.
+	if h.opts.ContextKey != nil && len(h.opts.Middleware) == 0 {
 		if v, ok := ctx.Value(h.opts.ContextKey).(slog.Value); ok {
 			for _, a := range v.Group() {
-				h.appendAttr(b, s, a)
+				h.appendAttr(b, s, a, &pend)
 			}
 		}
 	}
 	r.Attrs(func(a slog.Attr) bool {
-		h.appendAttr(b, s, a)
+		h.appendAttr(b, s, a, &pend)
 		return true
 	})
 
 	// Finish and send.
+	open := len(h.groups)
+	if len(pend) == 0 {
+		open += len(h.pending)
+	}
+	for i := 0; i < open; i++ {
+		h.fmt.PopGroup(b, s)
+	}
 	h.fmt.End(b, s, r.NumAttrs())
 	var n int
-	n, err = h.out.Write(*b)
+	if rw, ok := h.out.(hooks.RecordWriter); ok {
+		n, err = rw.WriteRecord(ctx, r, *b)
+	} else {
+		n, err = h.out.Write(*b)
+	}
 	if n != len(*b) && errors.Is(err, nil) {
 		err = io.ErrShortWrite
 	}
@@ -314,15 +420,37 @@ func (h *handler[S]) Handle(ctx context.Context, r slog.Record) (err error) {
 	return err
 }
 
+// WriteContextGroup writes a group of string values from the Context, if
+// there are any.
+func (h *handler[S]) writeContextGroup(b *buffer, s S, g string, kvs [][2]string) {
+	if len(kvs) == 0 {
+		return
+	}
+	if f := h.fmt.WriteContextGroup; f != nil {
+		f(b, s, g, kvs)
+		return
+	}
+	h.fmt.PushGroup(b, s, g)
+	for _, kv := range kvs {
+		h.fmt.AppendKey(b, s, kv[0])
+		h.fmt.AppendString(b, s, kv[1])
+	}
+	h.fmt.PopGroup(b, s)
+}
+
 // AppendAttr fully resolves the Attr value, then calls the appropriate
 // formatting hooks.
-func (h *handler[S]) appendAttr(b *buffer, s S, a slog.Attr) error {
+//
+// Any groups in "pend" are opened before the first value is written. Groups
+// that end up without any values are omitted.
+func (h *handler[S]) appendAttr(b *buffer, s S, a slog.Attr, pend *[]string) error {
 	a.Value = a.Value.Resolve()
 	kind := a.Value.Kind()
 	if kind != slog.KindGroup {
-		if a.Key == "" {
+		if a.Equal(slog.Attr{}) {
 			return nil
 		}
+		h.openGroups(b, s, pend)
 		h.fmt.AppendKey(b, s, a.Key)
 	}
 	switch v := a.Value; kind {
@@ -342,16 +470,22 @@ func (h *handler[S]) appendAttr(b *buffer, s S, a slog.Attr) error {
 		h.fmt.AppendTime(b, s, v.Time())
 	case slog.KindGroup:
 		attrs := v.Group()
-		if len(attrs) != 0 {
-			if a.Key != "" {
-				h.fmt.PushGroup(b, s, a.Key)
-			}
+		// Groups with an empty key are inlined.
+		if a.Key == "" {
 			for _, ga := range attrs {
-				h.appendAttr(b, s, ga)
-			}
-			if a.Key != "" {
-				h.fmt.PopGroup(b, s)
+				h.appendAttr(b, s, ga, pend)
 			}
+			break
+		}
+		n := len(*pend)
+		*pend = append(*pend, a.Key)
+		for _, ga := range attrs {
+			h.appendAttr(b, s, ga, pend)
+		}
+		if len(*pend) > n { // Never opened.
+			*pend = (*pend)[:n]
+		} else {
+			h.fmt.PopGroup(b, s)
 		}
 	case slog.KindAny:
 		return h.fmt.AppendAny(b, s, v.Any())
@@ -361,36 +495,76 @@ func (h *handler[S]) appendAttr(b *buffer, s S, a slog.Attr) error {
 	return nil
 }
 
+// OpenGroups opens the groups in "pend" and empties it.
+func (h *handler[S]) openGroups(b *buffer, s S, pend *[]string) {
+	for _, g := range *pend {
+		h.fmt.PushGroup(b, s, g)
+	}
+	*pend = (*pend)[:0]
+}
+
 // WithAttrs implements [slog.Handler].
 func (h *handler[S]) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	if len(h.opts.Middleware) != 0 {
+		return h.withScope(&scope{prev: h.scope, attrs: attrs})
+	}
 	p := h.prefmt.Clone()
 	s := h.pool.Get(h.groups, h.prefmt)
 	defer h.pool.Put(s)
+	pend := slices.Clone(h.pending)
 	for _, a := range attrs {
-		h.appendAttr(p, s, a)
+		h.appendAttr(p, s, a, &pend)
+	}
+	if h.fmt.EndAttrs != nil {
+		h.fmt.EndAttrs(p, s)
+	}
+	groups, pending := h.groups, h.pending
+	if len(pend) == 0 && len(h.pending) != 0 {
+		groups = append(slices.Clip(groups), h.pending...)
+		pending = nil
+	}
+	return &handler[S]{
+		out:     h.out,
+		opts:    h.opts,
+		fmt:     h.fmt,
+		pool:    h.pool,
+		prefmt:  p,
+		groups:  groups,
+		pending: pending,
 	}
+}
+
+// WithScope returns a handler like "h" with the scope "sc".
+func (h *handler[S]) withScope(sc *scope) *handler[S] {
 	return &handler[S]{
-		out:    h.out,
-		opts:   h.opts,
-		fmt:    h.fmt,
-		pool:   h.pool,
-		prefmt: p,
-		groups: h.groups,
+		out:   h.out,
+		opts:  h.opts,
+		fmt:   h.fmt,
+		pool:  h.pool,
+		scope: sc,
 	}
 }
 
 // WithGroup implements [slog.Handler].
+//
+// The group is only opened once an Attr is added to it.
 func (h *handler[S]) WithGroup(name string) slog.Handler {
-	p := h.prefmt.Clone()
-	s := h.pool.Get(h.groups, nil)
-	defer h.pool.Put(s)
-	h.fmt.PushGroup(p, s, name)
+	if name == "" {
+		return h
+	}
+	if len(h.opts.Middleware) != 0 {
+		return h.withScope(&scope{prev: h.scope, group: name})
+	}
 	return &handler[S]{
-		out:    h.out,
-		opts:   h.opts,
-		fmt:    h.fmt,
-		pool:   h.pool,
-		prefmt: p,
-		groups: append(h.groups, name),
+		out:     h.out,
+		opts:    h.opts,
+		fmt:     h.fmt,
+		pool:    h.pool,
+		prefmt:  h.prefmt,
+		groups:  h.groups,
+		pending: append(slices.Clip(h.pending), name),
 	}
 }
@@ -11,7 +11,8 @@ import (
 	"log/slog"
 	"net/netip"
 	"net/url"
-	"path/filepath"
+	"runtime/pprof"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,6 +23,8 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/quay/zlog/v2/internal/hooks"
 )
 
 func TestHandler(t *testing.T) {
@@ -125,6 +128,271 @@ func TestHandler(t *testing.T) {
 	})
 }
 
+// TestGroups checks the group and empty value handling beyond what
+// [slogtest] covers.
+func TestGroups(t *testing.T) {
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("l", "v"))
+	tt := []struct {
+		Name  string
+		Log   func(*slog.Logger)
+		JSON  string
+		Prose string
+	}{
+		{
+			Name:  "EmptyWithGroup",
+			Log:   func(l *slog.Logger) { l.WithGroup("").Info("msg", "a", 1) },
+			JSON:  `"a":1}`,
+			Prose: `a=1`,
+		},
+		{
+			Name:  "EmptyKey",
+			Log:   func(l *slog.Logger) { l.Info("msg", slog.Attr{}, slog.String("", "v")) },
+			JSON:  `"msg":"msg","":"v"}`,
+			Prose: `="v"`,
+		},
+		{
+			Name:  "EmptyNestedGroup",
+			Log:   func(l *slog.Logger) { l.Info("msg", slog.Group("g", slog.Group("h", slog.Attr{})), "a", 1) },
+			JSON:  `"msg":"msg","a":1}`,
+			Prose: `a=1`,
+		},
+		{
+			Name:  "Nested",
+			Log:   func(l *slog.Logger) { l.Info("msg", slog.Group("g", slog.Group("h", "a", 1), "b", 2), "c", 3) },
+			JSON:  `"g":{"h":{"a":1},"b":2},"c":3}`,
+			Prose: `g.h.a=1 g.b=2 c=3`,
+		},
+		{
+			Name:  "UnusedWithGroup",
+			Log:   func(l *slog.Logger) { l.With("a", "{").WithGroup("g").WithGroup("h").Info("msg") },
+			JSON:  `"msg":"msg","a":"{"}`,
+			Prose: `a="{"`,
+		},
+		{
+			Name:  "WithGroupWithAttrs",
+			Log:   func(l *slog.Logger) { l.WithGroup("g").With("a", 1).WithGroup("h").Info("msg", "b", 2) },
+			JSON:  `"g":{"a":1,"h":{"b":2}}}`,
+			Prose: `g.a=1 g.h.b=2`,
+		},
+		{
+			Name:  "TopLevelContext",
+			Log:   func(l *slog.Logger) { l.WithGroup("g").InfoContext(ctx, "msg", "a", 1) },
+			JSON:  `"goroutine":{"l":"v"},"g":{"a":1}}`,
+			Prose: `goroutine.l="v" g.a=1`,
+		},
+	}
+	opts := &Options{OmitSource: true, OmitTime: true}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tc.Log(slog.New(NewHandler(&buf, opts)))
+			got := strings.TrimSuffix(buf.String(), "\n")
+			if !json.Valid([]byte(got)) {
+				t.Errorf("invalid JSON: %#q", got)
+			}
+			if !strings.HasSuffix(got, tc.JSON) {
+				t.Errorf("got: %#q, want suffix: %#q", got, tc.JSON)
+			}
+
+			buf.Reset()
+			tc.Log(slog.New(proseHandler(&buf, opts)))
+			_, attrs, _ := strings.Cut(buf.String(), "\x1d ")
+			attrs = strings.NewReplacer("\x1f", "", "\x1e\n", "").Replace(attrs)
+			if got, want := strings.TrimSpace(attrs), tc.Prose; got != want {
+				t.Errorf("got: %#q, want: %#q", got, want)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	drop := func(_ context.Context, v *RecordView) bool { return v.Message != "drop" }
+	var redact func([]slog.Attr) []slog.Attr
+	redact = func(as []slog.Attr) []slog.Attr {
+		as = slices.Clone(as)
+		for i, a := range as {
+			switch {
+			case a.Key == "secret":
+				as[i].Value = slog.StringValue("REDACTED")
+			case a.Value.Kind() == slog.KindGroup:
+				as[i].Value = slog.GroupValue(redact(a.Value.Group())...)
+			}
+		}
+		return as
+	}
+	rewrite := func(_ context.Context, v *RecordView) bool {
+		v.Attrs = redact(v.Attrs)
+		return true
+	}
+	tt := []struct {
+		Name  string
+		Log   func(*slog.Logger)
+		JSON  string
+		Prose string
+	}{
+		{
+			Name:  "Drop",
+			Log:   func(l *slog.Logger) { l.Info("drop", "a", 1) },
+			JSON:  ``,
+			Prose: ``,
+		},
+		{
+			Name:  "Rewrite",
+			Log:   func(l *slog.Logger) { l.Info("msg", "secret", "hunter2", "a", 1) },
+			JSON:  `"msg":"msg","secret":"REDACTED","a":1}`,
+			Prose: `secret="REDACTED" a=1`,
+		},
+		{
+			Name:  "WithGroup",
+			Log:   func(l *slog.Logger) { l.WithGroup("g").Info("msg", "secret", "hunter2") },
+			JSON:  `"msg":"msg","g":{"secret":"REDACTED"}}`,
+			Prose: `g.secret="REDACTED"`,
+		},
+		{
+			Name:  "WithAttrs",
+			Log:   func(l *slog.Logger) { l.With("secret", "hunter2").Info("msg") },
+			JSON:  `"msg":"msg","secret":"REDACTED"}`,
+			Prose: `secret="REDACTED"`,
+		},
+		{
+			Name: "Nested",
+			Log: func(l *slog.Logger) {
+				l.With("a", 1).WithGroup("g").With("secret", "hunter2").WithGroup("h").Info("msg", "b", 2)
+			},
+			JSON:  `"msg":"msg","a":1,"g":{"secret":"REDACTED","h":{"b":2}}}`,
+			Prose: `a=1 g.secret="REDACTED" g.h.b=2`,
+		},
+		{
+			Name:  "EmptyGroup",
+			Log:   func(l *slog.Logger) { l.With("a", 1).WithGroup("g").Info("msg") },
+			JSON:  `"msg":"msg","a":1}`,
+			Prose: `a=1`,
+		},
+		{
+			Name: "Context",
+			Log: func(l *slog.Logger) {
+				ctx := context.WithValue(context.Background(), SetAttrs, slog.GroupValue(slog.String("secret", "hunter2")))
+				l.WithGroup("g").InfoContext(ctx, "msg", "a", 1)
+			},
+			JSON:  `"msg":"msg","g":{"secret":"REDACTED","a":1}}`,
+			Prose: `g.secret="REDACTED" g.a=1`,
+		},
+	}
+	opts := &Options{
+		OmitSource: true,
+		OmitTime:   true,
+		ContextKey: SetAttrs,
+		Middleware: []Middleware{drop, rewrite},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tc.Log(slog.New(NewHandler(&buf, opts)))
+			got := strings.TrimSuffix(buf.String(), "\n")
+			if tc.JSON == "" && got != "" {
+				t.Errorf("got: %#q, want nothing", got)
+			}
+			if !strings.HasSuffix(got, tc.JSON) {
+				t.Errorf("got: %#q, want suffix: %#q", got, tc.JSON)
+			}
+
+			buf.Reset()
+			tc.Log(slog.New(proseHandler(&buf, opts)))
+			_, attrs, _ := strings.Cut(buf.String(), "\x1d ")
+			attrs = strings.NewReplacer("\x1f", "", "\x1e\n", "").Replace(attrs)
+			if got, want := strings.TrimSpace(attrs), tc.Prose; got != want {
+				t.Errorf("got: %#q, want: %#q", got, want)
+			}
+		})
+	}
+
+	// A Handler holding its Attrs for the middleware still follows the rules.
+	t.Run("slogtest", func(t *testing.T) {
+		var buf bytes.Buffer
+		opts := &Options{Middleware: []Middleware{rewrite}}
+		slogtest.Run(t, func(*testing.T) slog.Handler {
+			buf.Reset()
+			return NewHandler(&buf, opts)
+		}, func(t *testing.T) map[string]any {
+			v := map[string]any{}
+			if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+				t.Errorf("in: %#q; error: %v", buf.Bytes(), err)
+			}
+			return v
+		})
+	})
+}
+
+// TestRecordSource checks that a record without a program counter can carry
+// its source in the Context, and that a "source" Attr is left alone.
+func TestRecordSource(t *testing.T) {
+	ts := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	tt := []struct {
+		Name  string
+		Src   *slog.Source
+		JSON  string
+		Prose string
+	}{
+		{
+			Name:  "Function",
+			Src:   &slog.Source{Function: "example.com/pkg.F"},
+			JSON:  `{"level":"INFO","source":"example.com/pkg.F","msg":"msg","a":1}`,
+			Prose: "INFO \x1f example.com/pkg.F\x1f msg\x1d a=1\x1f\x1e\n",
+		},
+		{
+			Name:  "File",
+			Src:   &slog.Source{File: "/src/pkg/file.go", Line: 12},
+			JSON:  `{"level":"INFO","source":"/src/pkg/file.go:12","msg":"msg","a":1}`,
+			Prose: "INFO \x1f pkg/file.go:12\x1f msg\x1d a=1\x1f\x1e\n",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := slog.NewRecord(ts, slog.LevelInfo, "msg", 0)
+			r.AddAttrs(slog.Int("a", 1))
+			ctx := hooks.WithSource(context.Background(), tc.Src)
+
+			var buf bytes.Buffer
+			opts := &Options{OmitTime: true}
+			if err := NewHandler(&buf, opts).Handle(ctx, r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := strings.TrimSpace(buf.String()), tc.JSON; got != want {
+				t.Errorf("got: %#q, want: %#q", got, want)
+			}
+
+			buf.Reset()
+			if err := proseHandler(&buf, opts).Handle(ctx, r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), tc.Prose; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+
+			buf.Reset()
+			opts.OmitSource = true
+			if err := NewHandler(&buf, opts).Handle(ctx, r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := strings.TrimSpace(buf.String()), `{"level":"INFO","msg":"msg","a":1}`; got != want {
+				t.Errorf("got: %#q, want: %#q", got, want)
+			}
+
+			// An Attr is only an Attr.
+			buf.Reset()
+			opts.OmitSource = false
+			r = slog.NewRecord(ts, slog.LevelInfo, "msg", 0)
+			r.AddAttrs(slog.String(slog.SourceKey, "x"))
+			if err := NewHandler(&buf, opts).Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := strings.TrimSpace(buf.String()), `{"level":"INFO","msg":"msg","source":"x"}`; got != want {
+				t.Errorf("got: %#q, want: %#q", got, want)
+			}
+		})
+	}
+}
+
 type jsonTester struct {
 	results *[]map[string]any
 	buf     bytes.Buffer
@@ -536,8 +804,6 @@ func (e *emulator) Results() []map[string]any {
 	return e.res
 }
 
-func isUS(r rune) bool { return r == 0x1f }
-
 func parseProseRecords(t *testing.T, buf *bytes.Buffer) func() []map[string]any {
 	t.Helper()
 	return func() []map[string]any {
@@ -552,96 +818,39 @@ func parseProseRecords(t *testing.T, buf *bytes.Buffer) func() []map[string]any
 func parseProseRecord(t *testing.T, line []byte) map[string]any {
 	t.Helper()
 	m := make(map[string]any)
-	// Validations:
 	if len(line) == 0 {
 		return m
 	}
-	// Trim an optional newline. If parsing a buffer full of lines, the
-	// splitting function may do this for us.
-	if line[len(line)-1] == '\n' {
-		line = line[:len(line)-1]
-	}
-	if line[len(line)-1] != 0x1e {
-		t.Error("no ␞")
+	rec, err := NewProseDecoder(bytes.NewReader(line)).Decode()
+	if err != nil {
+		t.Error(err)
 		return m
 	}
-	line = line[:len(line)-1]
-
-	// Split into the fixed records and attrs:
-	rec, attr, ok := bytes.Cut(line, []byte{0x1d})
-	if !ok {
-		t.Error("no ␝")
-		return m
+	m[slog.LevelKey] = rec.Level.String()
+	if rec.Source != "" {
+		m[slog.SourceKey] = rec.Source
 	}
-
-	// Handle fixed records:
-	recs := bytes.FieldsFunc(rec, isUS)
-	var l slog.Level
-	if err := l.UnmarshalText(bytes.TrimSpace(recs[0])); err != nil {
-		t.Error(err)
-	}
-	m[slog.LevelKey] = l.String()
-	recs = recs[1:]
-	switch len(recs) {
-	case 3:
-		m[slog.SourceKey] = string(bytes.TrimSpace(recs[0]))
-		recs = recs[1:]
-		fallthrough
-	case 2:
-		s := string(bytes.TrimSpace(recs[0]))
-		// Can be a path or a timestamp. Exploit the fact that we know
-		// the time is in RFC3339 format and will not have path
-		// separators.
-		if strings.Contains(s, string(filepath.Separator)) {
-			m[slog.SourceKey] = s
-		} else {
-			v, err := time.Parse(time.RFC3339, s)
-			if err != nil {
-				t.Error(err)
-			}
-			m[slog.TimeKey] = v
-		}
-		recs = recs[1:]
-		fallthrough
-	case 1:
-		m[slog.MessageKey] = string(bytes.TrimSpace(recs[0]))
+	if !rec.Time.IsZero() {
+		m[slog.TimeKey] = rec.Time
 	}
+	m[slog.MessageKey] = rec.Message
+	addProseAttrs(m, rec.Attrs)
+	return m
+}
 
-	// Handle attrs:
-	for _, f := range bytes.FieldsFunc(attr, isUS) {
-		f = bytes.TrimSpace(f)
-		if len(f) == 0 {
+// AddProseAttrs adds "as" to "m", with groups as nested maps and all values
+// as the strings they were written as.
+func addProseAttrs(m map[string]any, as []slog.Attr) {
+	for _, a := range as {
+		if a.Value.Kind() != slog.KindGroup {
+			m[a.Key] = a.Value.String()
 			continue
 		}
-		k, v, ok := strings.Cut(string(f), "=")
+		n, ok := m[a.Key].(map[string]any)
 		if !ok {
-			t.Errorf(`no "=": %+q`, string(f))
-			continue
-		}
-		if v[0] == '"' {
-			s, err := strconv.Unquote(v)
-			if err != nil {
-				t.Errorf("unable to unquote: %v", err)
-				continue
-			}
-			v = s
-		}
-		// Reconstruct groups:
-		ks := strings.Split(k, ".")
-		cur := m
-		for i, k := range ks {
-			if i == len(ks)-1 {
-				cur[k] = v
-				continue
-			}
-			n, ok := cur[k]
-			if !ok {
-				n = make(map[string]any)
-				cur[k] = n
-			}
-			cur = n.(map[string]any)
+			n = make(map[string]any)
+			m[a.Key] = n
 		}
+		addProseAttrs(n, a.Value.Group())
 	}
-
-	return m
 }
@@ -0,0 +1,37 @@
+// Package hooks holds the extension points of the zlog handlers that are only
+// for the other packages in this module.
+package hooks
+
+import (
+	"context"
+	"log/slog"
+)
+
+// RecordWriter is implemented by writers that want the record, as emitted, and
+// its Context along with the formatted output.
+//
+// The record has been through any middleware, and is written while holding
+// the handler's lock.
+type RecordWriter interface {
+	WriteRecord(ctx context.Context, r slog.Record, b []byte) (int, error)
+}
+
+// Emit formats and writes "r" via "h", which must be a handler from the zlog
+// package, without running its middleware or checking its level and flight
+// buffer. It's set by the zlog package.
+var Emit func(h slog.Handler, ctx context.Context, r slog.Record) error
+
+// SourceKey is the Context key for a *slog.Source set by [WithSource].
+type sourceKey struct{}
+
+// WithSource returns a Context that causes a handler to report "src" as the
+// source of records that have no program counter.
+func WithSource(ctx context.Context, src *slog.Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, src)
+}
+
+// Source returns the source stored by [WithSource], if any.
+func Source(ctx context.Context) *slog.Source {
+	src, _ := ctx.Value(sourceKey{}).(*slog.Source)
+	return src
+}
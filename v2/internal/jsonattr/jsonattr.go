@@ -0,0 +1,92 @@
+// Package jsonattr decodes JSON objects into [slog.Attr] values, keeping the
+// order of the keys.
+package jsonattr
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+// DecodeField reads a key and a value from the decoder, which should have
+// [json.Decoder.UseNumber] set.
+//
+// Objects are returned as []slog.Attr, numbers as json.Number.
+func DecodeField(dec *json.Decoder) (string, any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return "", nil, err
+	}
+	k, _ := t.(string)
+	v, err := DecodeValue(dec)
+	return k, v, err
+}
+
+// DecodeValue reads a value from the decoder. See [DecodeField].
+func DecodeValue(dec *json.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		as := []slog.Attr{}
+		for dec.More() {
+			k, v, err := DecodeField(dec)
+			if err != nil {
+				return nil, err
+			}
+			as = append(as, ToAttr(k, v))
+		}
+		_, err := dec.Token()
+		return as, err
+	case json.Delim('['):
+		vs := []any{}
+		for dec.More() {
+			v, err := DecodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			if as, ok := v.([]slog.Attr); ok {
+				v = AttrsToMap(as)
+			}
+			vs = append(vs, v)
+		}
+		_, err := dec.Token()
+		return vs, err
+	}
+	return t, nil
+}
+
+// ToAttr converts a decoded value to an Attr.
+func ToAttr(k string, v any) slog.Attr {
+	switch v := v.(type) {
+	case []slog.Attr:
+		return slog.Attr{Key: k, Value: slog.GroupValue(v...)}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return slog.Int64(k, i)
+		}
+		if f, err := v.Float64(); err == nil {
+			return slog.Float64(k, f)
+		}
+		return slog.String(k, v.String())
+	case string:
+		return slog.String(k, v)
+	case bool:
+		return slog.Bool(k, v)
+	}
+	return slog.Any(k, v)
+}
+
+// AttrsToMap converts decoded Attrs back to a map, for objects inside arrays.
+func AttrsToMap(as []slog.Attr) map[string]any {
+	m := make(map[string]any, len(as))
+	for _, a := range as {
+		if a.Value.Kind() == slog.KindGroup {
+			m[a.Key] = AttrsToMap(a.Value.Group())
+			continue
+		}
+		m[a.Key] = a.Value.Any()
+	}
+	return m
+}
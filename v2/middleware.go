@@ -0,0 +1,115 @@
+package zlog
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Middleware is a stage in the record processing pipeline configured via
+// [Options.Middleware].
+//
+// Stages are called in order with the [context.Context] passed to the
+// Handler and a [RecordView] of the record being handled. A stage may modify
+// the view in place to enrich, filter, or rewrite the record. Returning false
+// vetoes the record; later stages are not called and nothing is emitted.
+//
+// Stages see every Attr of the record: those added via
+// [slog.Handler.WithAttrs], those retrieved via [Options.ContextKey], and those
+// added to the record itself, in that order. Groups opened with
+// [slog.Handler.WithGroup] are groups in the view, so a stage looking for a
+// key may need to descend into them.
+//
+// Because the pipeline is part of the [Options], it applies to every Handler
+// derived via WithAttrs and WithGroup. Those Handlers keep their Attrs
+// unformatted, so that the stages can see them, which makes every record
+// somewhat more expensive to format.
+type Middleware func(context.Context, *RecordView) bool
+
+// RecordView is a mutable view of an [slog.Record], for use by [Middleware].
+type RecordView struct {
+	// Time is the time of the record. Setting the zero value causes no time
+	// to be emitted.
+	Time time.Time
+	// Message is the log message.
+	Message string
+	// Level is the level of the record. The record is dropped if, after all
+	// stages have run, the level is not enabled.
+	//
+	// Note that records are filtered by level before the pipeline runs, so
+	// raising the level of a record can not cause it to be emitted.
+	Level slog.Level
+	// PC is the program counter used for source information. Setting zero
+	// causes no source information to be emitted.
+	PC uintptr
+	// Attrs are the record's Attrs, including those from the Handler and the
+	// Context.
+	Attrs []slog.Attr
+}
+
+// RunMiddleware passes the record through the configured pipeline, reporting
+// the resulting record and whether it should be emitted.
+func (h *handler[S]) runMiddleware(ctx context.Context, r slog.Record) (slog.Record, bool) {
+	var tail []slog.Attr
+	if h.opts.ContextKey != nil {
+		if v, ok := ctx.Value(h.opts.ContextKey).(slog.Value); ok {
+			tail = append(tail, v.Group()...)
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		tail = append(tail, a)
+		return true
+	})
+	v := RecordView{
+		Time:    r.Time,
+		Message: r.Message,
+		Level:   r.Level,
+		PC:      r.PC,
+		Attrs:   h.scope.Attrs(tail),
+	}
+	for _, m := range h.opts.Middleware {
+		if !m(ctx, &v) {
+			return r, false
+		}
+	}
+	if v.Level != r.Level && !h.Enabled(ctx, v.Level) {
+		return r, false
+	}
+	out := slog.NewRecord(v.Time, v.Level, v.Message, v.PC)
+	out.AddAttrs(v.Attrs...)
+	return out, true
+}
+
+// Scope is a call to WithAttrs or WithGroup on a handler with middleware,
+// linked to the calls before it.
+type scope struct {
+	prev  *scope
+	attrs []slog.Attr
+	group string
+}
+
+// Attrs returns the Attrs added by "sc" and the calls before it, with "tail"
+// added in the innermost group.
+func (sc *scope) Attrs(tail []slog.Attr) []slog.Attr {
+	var calls []*scope
+	for ; sc != nil; sc = sc.prev {
+		calls = append(calls, sc)
+	}
+	slices.Reverse(calls)
+	return nestAttrs(calls, tail)
+}
+
+// NestAttrs returns the Attrs added by "calls", in order, with "tail" added in
+// the innermost group.
+func nestAttrs(calls []*scope, tail []slog.Attr) []slog.Attr {
+	var out []slog.Attr
+	for i, c := range calls {
+		if c.group != "" {
+			g := nestAttrs(calls[i+1:], tail)
+			return append(out, slog.Attr{Key: c.group, Value: slog.GroupValue(g...)})
+		}
+		out = append(out, c.attrs...)
+	}
+	return append(out, tail...)
+}
@@ -1,10 +1,14 @@
 package zlog
 
 import (
+	"context"
 	"errors"
 	"io"
+	"log/slog"
 	"os"
 	"sync"
+
+	"github.com/quay/zlog/v2/internal/hooks"
 )
 
 // NoCopy is a trick for ensuring a handler isn't copied around.
@@ -30,6 +34,17 @@ func (w *syncWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// WriteRecord implements [hooks.RecordWriter], falling back to Write if the
+// inner Writer doesn't implement it.
+func (w *syncWriter) WriteRecord(ctx context.Context, r slog.Record, b []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if rw, ok := w.Writer.(hooks.RecordWriter); ok {
+		return rw.WriteRecord(ctx, r, b)
+	}
+	return w.Writer.Write(b)
+}
+
 // InK8s reports whether this process is (probably) being run inside a
 // kubernetes pod. This relies on some default behavior which is trivially
 // changed in a PodSpec.
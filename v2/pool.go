@@ -127,6 +127,9 @@ var pools = map[reflect.Type]interface{}{
 	reflect.TypeOf(stateJournal{}): &statePool[*stateJournal]{
 		New: func() *stateJournal { return new(stateJournal) },
 	},
+	reflect.TypeOf(stateProse{}): &statePool[*stateProse]{
+		New: func() *stateProse { return new(stateProse) },
+	},
 }
 
 // GetPool returns the type-specific [statePool].
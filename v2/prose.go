@@ -1,7 +1,6 @@
 package zlog
 
 import (
-	"bytes"
 	"encoding"
 	"encoding/base64"
 	"encoding/json"
@@ -16,128 +15,167 @@ import (
 	"time"
 )
 
-// DefaultProseColors are the colors used when the "ZLOG_COLORS" environment
-// variable isn't set.
+// DefaultProseColors are the colors used when neither the "ZLOG_COLORS" nor
+// the "ZLOG_THEME" environment variable is set.
 const DefaultProseColors = `31:33:32:3:96:93::36::1;32:1;31:1;33:32:95:33:4:34:35:21:91`
 
 // ProseHandler returns a handler emitting the "prose" format.
-func proseHandler(w io.Writer, opts *Options) *handler[*stateJournal] {
+func proseHandler(w io.Writer, opts *Options) *handler[*stateProse] {
 	var p *ansiPrinter
 	// Populate "p" if the configuration seems to support it.
-	if opts.forceANSI || (len(os.Getenv("NO_COLOR")) == 0 && isatty(w)) {
-		v := DefaultProseColors
-		if z, ok := os.LookupEnv(`ZLOG_COLORS`); ok {
-			// Scrub the string from the environment for disallowed runes.
-			v = strings.Map(func(r rune) rune {
-				if r < '0' || r > ';' {
-					r = -1
-				}
-				return r
-			}, z)
-		}
-		s := strings.Split(v, ":")
-		// Ensure that the array is the correct size.
-		if len(s) < printerSize {
-			s = append(s, make([]string, printerSize-len(s))...)
-		}
-		p = (*ansiPrinter)((*[printerSize]string)(s))
+	caps := detectTerm(os.Getenv, isatty(w))
+	if opts.forceANSI {
+		caps = termCaps{depth: colorTrue, links: true}
+	}
+	if caps.depth != colorNone {
+		z, ok := os.LookupEnv(`ZLOG_COLORS`)
+		p = parseColors(os.Getenv(`ZLOG_THEME`), z, ok)
+		p.limit(caps)
 	}
 
-	f := formatter[*stateJournal]{
+	po := opts.Prose
+	if po == nil {
+		po = &ProseOptions{}
+	}
+	layout := DefaultProseLayout
+	if po.Layout != "" {
+		layout = po.Layout
+	}
+	lay := parseLayout(layout)
+	clock := newProseClock(po)
+	x := newProseExpander(p, po)
+	src := newProseSource(po)
+	wrap := newProseWrapper(w, opts, po)
+	var corr proseCorrelation
+	if p != nil {
+		corr = po.CorrelationKeys
+	}
+
+	f := formatter[*stateProse]{
 		PprofKey:   "goroutine",
 		BaggageKey: "baggage",
-		Start:      func(b *buffer, s *stateJournal) {},
-		End: func(b *buffer, s *stateJournal, n int) {
+		Start:      func(b *buffer, s *stateProse) {},
+		End: func(b *buffer, s *stateProse, n int) {
+			s.correlate(b, p)
+			if !lay.attrs {
+				*b = (*b)[:s.attrsAt]
+			} else {
+				wrap.Apply(b, s.attrsAt)
+			}
 			b.Unwrite()
 			b.Write([]byte("\x1e\n"))
 		},
-		WriteLevel: func(b *buffer, s *stateJournal, l slog.Level) {
+		WriteLevel: func(b *buffer, s *stateProse, l slog.Level) {
 			v := l.String()
-			ct := 5 - len(v)
-			switch {
-			case l >= slog.LevelError:
-				p.ErrorLevel(b, v)
-			case l >= slog.LevelWarn:
-				p.WarnLevel(b, v)
-			case l >= slog.LevelInfo:
-				p.InfoLevel(b, v)
-			default:
-				p.DebugLevel(b, v)
-			}
-			b.WriteString(strings.Repeat(" ", ct))
-			emitUnitSep(b)
+			s.fields[layoutLevel].Capture(func(b *buffer) {
+				switch {
+				case l >= slog.LevelError:
+					p.ErrorLevel(b, v)
+				case l >= slog.LevelWarn:
+					p.WarnLevel(b, v)
+				case l >= slog.LevelInfo:
+					p.InfoLevel(b, v)
+				default:
+					p.DebugLevel(b, v)
+				}
+			})
 		},
-		WriteSource: func(b *buffer, s *stateJournal, f *runtime.Frame) {
-			defer emitUnitSep(b)
-			defer p.Source(b)()
-			b.WriteString(f.Function)
+		WriteSource: func(b *buffer, s *stateProse, f *runtime.Frame) {
+			s.fields[layoutSource].Capture(func(b *buffer) {
+				src.Write(b, p, f, lay.width[layoutSource])
+			})
 		},
-		WriteTime: func(b *buffer, s *stateJournal, t time.Time) {
-			p.Timestamp(b, t)
-			emitUnitSep(b)
+		WriteTime: func(b *buffer, s *stateProse, t time.Time) {
+			t, layout, delta, ok := clock.Next(t)
+			s.fields[layoutTime].Capture(func(b *buffer) {
+				p.Timestamp(b, t, layout)
+			})
+			if ok {
+				s.fields[layoutDelta].Capture(func(b *buffer) {
+					defer p.emitEscape(b, printTimestamp)()
+					appendDelta(b, delta)
+				})
+			}
 		},
-		WriteMessage: func(b *buffer, s *stateJournal, msg string) {
-			p.Message(b, msg)
-			emitGroupSep(b)
+		WriteMessage: func(b *buffer, s *stateProse, msg string) {
+			s.fields[layoutMessage].Capture(func(b *buffer) {
+				p.Message(b, x.Message(msg))
+			})
+			lay.Render(b, s)
+			s.attrsAt = len(*b)
 		},
-		AppendKey: func(b *buffer, s *stateJournal, k string) {
+		AppendKey: func(b *buffer, s *stateProse, k string) {
+			s.correlate(b, p)
+			if corr.Match(s.prefix, k) {
+				defer func() { s.corrAt = len(*b) }()
+			}
 			defer b.WriteByte('=')
 			defer p.Key(b)()
 			if len(s.prefix) != 0 {
 				b.Write(s.prefix)
 				b.WriteByte('.')
 			}
-			b.WriteString(k)
+			b.WriteString(sanitize(k))
 		},
-		AppendString: func(b *buffer, s *stateJournal, v string) {
-			p.String(b, v)
+		AppendString: func(b *buffer, s *stateProse, v string) {
+			if !x.Block(b, v, printString) {
+				p.String(b, v)
+			}
 			emitUnitSep(b)
 		},
-		AppendBool: func(b *buffer, s *stateJournal, v bool) {
+		AppendBool: func(b *buffer, s *stateProse, v bool) {
 			p.Bool(b, v)
 			emitUnitSep(b)
 		},
-		AppendInt64: func(b *buffer, s *stateJournal, v int64) {
+		AppendInt64: func(b *buffer, s *stateProse, v int64) {
 			defer emitUnitSep(b)
 			defer p.Number(b)()
 			*b = strconv.AppendInt(*b, v, 10)
 		},
-		AppendUint64: func(b *buffer, s *stateJournal, v uint64) {
+		AppendUint64: func(b *buffer, s *stateProse, v uint64) {
 			defer emitUnitSep(b)
 			defer p.Number(b)()
 			*b = strconv.AppendUint(*b, v, 10)
 		},
-		AppendFloat64: func(b *buffer, s *stateJournal, v float64) {
+		AppendFloat64: func(b *buffer, s *stateProse, v float64) {
 			defer emitUnitSep(b)
 			defer p.Number(b)()
 			*b = strconv.AppendFloat(*b, v, 'g', -1, 64)
 		},
-		AppendTime: func(b *buffer, s *stateJournal, t time.Time) {
+		AppendTime: func(b *buffer, s *stateProse, t time.Time) {
 			p.Time(b, t)
 			emitUnitSep(b)
 		},
-		AppendDuration: func(b *buffer, s *stateJournal, d time.Duration) {
+		AppendDuration: func(b *buffer, s *stateProse, d time.Duration) {
 			p.Duration(b, d)
 			emitUnitSep(b)
 		},
-		AppendAny: func(b *buffer, s *stateJournal, v any) (err error) {
+		AppendAny: func(b *buffer, s *stateProse, v any) (err error) {
 			defer emitUnitSep(b)
 			switch v := v.(type) {
 			case *url.URL:
 				p.URL(b, v)
 			case error:
-				p.Error(b, v)
+				if str := v.Error(); !x.Block(b, str, printErrorVal) {
+					p.Error(b, str)
+				}
 			case encoding.TextMarshaler:
 				var t []byte
 				t, err = v.MarshalText()
 				if err != nil {
 					return err
 				}
-				p.Text(b, t)
+				if !x.Block(b, string(t), printTextUnmarshaler) {
+					p.Text(b, t)
+				}
 			case fmt.Stringer:
-				p.String(b, v.String())
+				if str := v.String(); !x.Block(b, str, printString) {
+					p.String(b, str)
+				}
 			case fmt.GoStringer:
-				p.GoStringer(b, v)
+				if str := v.GoString(); !x.Block(b, str, printGoString) {
+					p.GoStringer(b, str)
+				}
 			case encoding.BinaryMarshaler:
 				var t []byte
 				t, err = v.MarshalBinary()
@@ -153,29 +191,47 @@ func proseHandler(w io.Writer, opts *Options) *handler[*stateJournal] {
 				if err != nil {
 					return err
 				}
-				p.JSON(b, t)
+				if !x.JSON(b, t) {
+					p.JSON(b, t)
+				}
 			default:
-				p.Reflect(b, v)
+				if str := fmt.Sprint(v); !x.Block(b, str, printReflect) {
+					p.Reflect(b, str)
+				}
 			}
 			return nil
 		},
-		PushGroup: func(b *buffer, s *stateJournal, g string) { s.pushGroup(g) },
-		PopGroup: func(b *buffer, s *stateJournal) {
-			s.groups = s.groups[:len(s.groups)-1]
-			i := bytes.LastIndexByte(s.prefix, '.')
-			i--
-			if i < 0 {
-				i = 0
-			}
-			s.prefix = s.prefix[:i]
-		},
+		PushGroup: func(b *buffer, s *stateProse, g string) { s.pushGroup(sanitize(g)) },
+		PopGroup:  func(b *buffer, s *stateProse) { s.popGroup() },
 	}
 
-	return &handler[*stateJournal]{
+	f.EndAttrs = func(b *buffer, s *stateProse) { s.correlate(b, p) }
+
+	f.WriteContextGroup = func(b *buffer, s *stateProse, g string, kvs [][2]string) {
+		style := po.Goroutine
+		if g == f.BaggageKey {
+			style = po.Baggage
+		}
+		switch style {
+		case GroupHidden:
+			return
+		case GroupPrefixed:
+			f.PushGroup(b, s, g)
+			defer f.PopGroup(b, s)
+		}
+		for _, kv := range kvs {
+			f.AppendKey(b, s, kv[0])
+			f.AppendString(b, s, kv[1])
+		}
+		// The state is reset before the rest of the Attrs are written.
+		s.correlate(b, p)
+	}
+
+	return &handler[*stateProse]{
 		out:  &syncWriter{Writer: w},
 		opts: opts,
 		fmt:  &f,
-		pool: getPool[*stateJournal](),
+		pool: getPool[*stateProse](),
 	}
 }
 
@@ -193,15 +249,20 @@ func emitGroupSep(b *buffer) { b.Write([]byte("\x1d ")) }
 //
 // All methods are OK to call on a nil receiver, and will result in no escape
 // sequences. If the method controls the printing, it will still happen.
-type ansiPrinter [printerSize]string
+type ansiPrinter struct {
+	// Sgr is the SGR parameters for each of the "print" indexes.
+	sgr   [printerSize]string
+	depth colorDepth
+	links bool
+}
 
 // EmitEscape prints escape "i" and returns a function to reset the formatting.
 func (p *ansiPrinter) emitEscape(b *buffer, i int) func() {
-	if p == nil || p[i] == `` {
+	if p == nil || p.sgr[i] == `` {
 		return func() {}
 	}
 	b.WriteString("\x1b[")
-	b.WriteString(p[i])
+	b.WriteString(p.sgr[i])
 	b.WriteByte('m')
 	return func() {
 		b.WriteString("\x1b[m")
@@ -237,10 +298,11 @@ func (p *ansiPrinter) Source(b *buffer) func() {
 	return p.emitEscape(b, printSource)
 }
 
-// Timestamp prints "t" with the "Timestamp" formatting.
-func (p *ansiPrinter) Timestamp(b *buffer, t time.Time) {
+// Timestamp prints "t" in the time layout "layout" with the "Timestamp"
+// formatting.
+func (p *ansiPrinter) Timestamp(b *buffer, t time.Time, layout string) {
 	defer p.emitEscape(b, printTimestamp)()
-	*b = t.UTC().AppendFormat(*b, time.RFC3339)
+	*b = t.AppendFormat(*b, layout)
 }
 
 // Time prints "t" with the "time.Time" formatting.
@@ -249,10 +311,10 @@ func (p *ansiPrinter) Time(b *buffer, t time.Time) {
 	*b = t.UTC().AppendFormat(*b, time.RFC3339)
 }
 
-// Message prints "s" with the "message" formatting.
+// Message sanitizes and prints "s" with the "message" formatting.
 func (p *ansiPrinter) Message(b *buffer, s string) {
 	defer p.emitEscape(b, printMessage)()
-	b.WriteString(s)
+	b.WriteString(sanitize(s))
 }
 
 // Key emits the "key" formatting.
@@ -288,28 +350,25 @@ func (p *ansiPrinter) Duration(b *buffer, d time.Duration) {
 	b.WriteString(d.String())
 }
 
-// Error formats and prints "err" with the "errorValue" formatting.
-//
-// If "err" is nil, "<nil>" is printed.
-func (p *ansiPrinter) Error(b *buffer, err error) {
+// Error sanitizes and prints the error text "s" with the "errorValue"
+// formatting.
+func (p *ansiPrinter) Error(b *buffer, s string) {
 	defer p.emitEscape(b, printErrorVal)()
-	s := "<nil>"
-	if err != nil {
-		s = err.Error()
-	}
-	b.WriteString(s)
+	b.WriteString(sanitize(s))
 }
 
-// Text prints the preformatted bytes "t" with the "TextUnmarshaler" formatting.
+// Text sanitizes and prints the preformatted bytes "t" with the
+// "TextUnmarshaler" formatting.
 func (p *ansiPrinter) Text(b *buffer, t []byte) {
 	defer p.emitEscape(b, printTextUnmarshaler)()
-	*b = append(*b, t...)
+	b.WriteString(sanitize(string(t)))
 }
 
-// GoStringer calls "GoString" and prints the result with the "GoStringer" formatting.
-func (p *ansiPrinter) GoStringer(b *buffer, v fmt.GoStringer) {
+// GoStringer sanitizes and prints the result of a "GoString" call "s" with
+// the "GoStringer" formatting.
+func (p *ansiPrinter) GoStringer(b *buffer, s string) {
 	defer p.emitEscape(b, printGoString)()
-	b.WriteString(v.GoString())
+	b.WriteString(sanitize(s))
 }
 
 // Base64 base64-encodes "s" and prints it with the "binary" formatting.
@@ -327,30 +386,51 @@ func (p *ansiPrinter) Hex(b *buffer, s []byte) {
 	}
 }
 
-// JSON prints the preformatted bytes "t" with the "JSON" formatting.
+// JSON sanitizes and prints the preformatted bytes "t" with the "JSON"
+// formatting.
 func (p *ansiPrinter) JSON(b *buffer, t []byte) {
 	defer p.emitEscape(b, printJSON)()
-	*b = append(*b, t...)
+	b.WriteString(sanitize(string(t)))
 }
 
-// Reflect uses the fmt package to print "v" with the "reflect" formatting.
-func (p *ansiPrinter) Reflect(b *buffer, v any) {
+// Reflect sanitizes and prints "s", a value formatted by the fmt package,
+// with the "reflect" formatting.
+func (p *ansiPrinter) Reflect(b *buffer, s string) {
 	defer p.emitEscape(b, printReflect)()
-	fmt.Fprint(b, v)
+	b.WriteString(sanitize(s))
 }
 
-// URL prints prints "u" with OSC-8 formatting applied.
+// URL prints "u" with OSC-8 formatting and the "URL" formatting applied.
 func (p *ansiPrinter) URL(b *buffer, u *url.URL) {
-	s := u.String()
-	if p == nil {
-		b.WriteString(s)
-		return
+	// The String method doesn't escape everything, such as the opaque part.
+	s := sanitize(u.String())
+	defer p.Link(b, s)()
+	defer p.emitEscape(b, printURL)()
+	b.WriteString(s)
+}
+
+// LinkSpace removes the whitespace that [sanitize] keeps from hyperlink
+// targets.
+var linkSpace = strings.NewReplacer("\n", "", "\t", "")
+
+// Linking reports whether [ansiPrinter.Link] emits hyperlinks.
+func (p *ansiPrinter) Linking() bool {
+	return p != nil && p.links
+}
+
+// Link starts an OSC-8 hyperlink to "target" and returns a function to end
+// it. The target is sanitized, and newlines and tabs are removed, so that it
+// can't end the sequence early.
+func (p *ansiPrinter) Link(b *buffer, target string) func() {
+	if !p.Linking() {
+		return func() {}
 	}
 	b.WriteString("\x1b]8;;")
-	b.WriteString(s)
+	b.WriteString(linkSpace.Replace(sanitize(target)))
 	b.WriteString("\x1b\\")
-	b.WriteString(s)
-	b.WriteString("\x1b]8;;\x1b\\")
+	return func() {
+		b.WriteString("\x1b]8;;\x1b\\")
+	}
 }
 
 // These are indexes into an array containing SGR parameters.
@@ -375,5 +455,7 @@ const (
 	printBinary
 	printJSON
 	printReflect
+	printURL
+	printCorrelation
 	printerSize
 )
@@ -0,0 +1,124 @@
+package zlog
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ProseThemes are the named themes that can be selected with the
+// "ZLOG_THEME" environment variable.
+//
+// Themes are written in the keyed "ZLOG_COLORS" syntax and applied on top of
+// [DefaultProseColors].
+var proseThemes = map[string]string{
+	"dark": ``,
+	"light": `level.debug=2:source=38;5;30:timestamp=38;5;94:key=38;5;24:` +
+		`true=1;38;5;28:false=1;38;5;124:number=1;38;5;130:time=38;5;28:` +
+		`duration=38;5;90:error=38;5;130:text=4:gostring=38;5;25:` +
+		`binary=38;5;90:json=38;5;18:reflect=38;5;160`,
+	"solarized": `level.error=#dc322f:level.warn=#b58900:level.info=#859900:level.debug=3;#839496:` +
+		`source=#2aa198:timestamp=#b58900:msg=:key=#268bd2:string=:` +
+		`true=1;#859900:false=1;#dc322f:number=1;#cb4b16:time=#859900:` +
+		`duration=#d33682:error=#cb4b16:text=4:gostring=#268bd2:` +
+		`binary=#6c71c4:json=#6c71c4:reflect=#dc322f:url=4`,
+}
+
+// ColorNames are the keys used in the keyed "ZLOG_COLORS" syntax, in the same
+// order as the positional syntax.
+var colorNames = [printerSize]string{
+	printErrorLevel:      "level.error",
+	printWarnLevel:       "level.warn",
+	printInfoLevel:       "level.info",
+	printDebugLevel:      "level.debug",
+	printSource:          "source",
+	printTimestamp:       "timestamp",
+	printMessage:         "msg",
+	printKey:             "key",
+	printString:          "string",
+	printTrue:            "true",
+	printFalse:           "false",
+	printNumber:          "number",
+	printTime:            "time",
+	printDuration:        "duration",
+	printErrorVal:        "error",
+	printTextUnmarshaler: "text",
+	printGoString:        "gostring",
+	printBinary:          "binary",
+	printJSON:            "json",
+	printReflect:         "reflect",
+	printURL:             "url",
+	printCorrelation:     "correlation",
+}
+
+// ParseColors returns the colors for the named theme, overridden by the
+// "ZLOG_COLORS" value "spec" if "ok" is set.
+//
+// If "spec" is in the positional syntax, it replaces the theme entirely.
+func parseColors(theme, spec string, ok bool) *ansiPrinter {
+	var p ansiPrinter
+	if ok && !strings.Contains(spec, "=") {
+		// Scrub the string from the environment for disallowed runes.
+		spec = strings.Map(func(r rune) rune {
+			if r < '0' || r > ';' {
+				r = -1
+			}
+			return r
+		}, spec)
+		copy(p.sgr[:], strings.Split(spec, ":"))
+		return &p
+	}
+	copy(p.sgr[:], strings.Split(DefaultProseColors, ":"))
+	p.apply(proseThemes[theme])
+	if ok {
+		p.apply(spec)
+	}
+	return &p
+}
+
+// Apply sets the colors from "spec", which is in the keyed syntax. Unknown
+// keys are ignored.
+func (p *ansiPrinter) apply(spec string) {
+	for _, kv := range strings.Split(spec, ":") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		for i, n := range colorNames {
+			if n == k {
+				p.sgr[i] = parseSGR(v)
+				break
+			}
+		}
+	}
+}
+
+// ParseSGR converts a color value to SGR parameters.
+//
+// The value is a series of parameters separated by ";", where a parameter of
+// the form "#rrggbb" is expanded to a truecolor foreground. Invalid parameters
+// are dropped.
+func parseSGR(v string) string {
+	var out []string
+	for _, s := range strings.Split(v, ";") {
+		s = strings.TrimSpace(s)
+		switch {
+		case s == "":
+		case s[0] == '#':
+			n, err := strconv.ParseUint(s[1:], 16, 32)
+			if len(s) != 7 || err != nil {
+				continue
+			}
+			out = append(out, "38", "2",
+				strconv.FormatUint(n>>16, 10),
+				strconv.FormatUint(n>>8&0xff, 10),
+				strconv.FormatUint(n&0xff, 10))
+		default:
+			if _, err := strconv.ParseUint(s, 10, 8); err != nil {
+				continue
+			}
+			out = append(out, s)
+		}
+	}
+	return strings.Join(out, ";")
+}
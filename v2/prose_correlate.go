@@ -0,0 +1,74 @@
+package zlog
+
+import (
+	"bytes"
+	"hash/fnv"
+	"strconv"
+)
+
+// ProseCorrelation is the set of keys whose values are colored by a hash of
+// the value, so that records sharing a value share a color.
+type proseCorrelation []string
+
+// Match reports whether the key "k" in the group "prefix" is a correlation
+// key.
+func (c proseCorrelation) Match(prefix []byte, k string) bool {
+	for _, key := range c {
+		if len(prefix) == 0 {
+			if key == k {
+				return true
+			}
+			continue
+		}
+		if len(key) == len(prefix)+1+len(k) &&
+			key[:len(prefix)] == string(prefix) &&
+			key[len(prefix)] == '.' &&
+			key[len(prefix)+1:] == k {
+			return true
+		}
+	}
+	return false
+}
+
+// CorrelationPalette is the set of 256-color palette entries used for
+// correlation values: the colors from the 6×6×6 cube that are neither too dark
+// nor too light to read on common backgrounds.
+var correlationPalette = func() (out []int) {
+	for r := 0; r < 6; r++ {
+		for g := 0; g < 6; g++ {
+			for b := 0; b < 6; b++ {
+				if sum := r + g + b; sum < 4 || sum > 11 || (r == g && g == b) {
+					continue
+				}
+				out = append(out, 16+36*r+6*g+b)
+			}
+		}
+	}
+	return out
+}()
+
+// Correlate rewrites the value written since the correlation key, if any,
+// with a color derived from the value.
+func (s *stateProse) correlate(b *buffer, p *ansiPrinter) {
+	at := s.corrAt
+	if at == 0 {
+		return
+	}
+	s.corrAt = 0
+	v := appendStripped(nil, bytes.TrimSuffix((*b)[at:], []byte("\x1f ")))
+	h := fnv.New32a()
+	h.Write(v)
+	c := correlationPalette[h.Sum32()%uint32(len(correlationPalette))]
+
+	*b = (*b)[:at]
+	b.WriteString("\x1b[")
+	if p.sgr[printCorrelation] != "" {
+		b.WriteString(p.sgr[printCorrelation])
+		b.WriteByte(';')
+	}
+	b.WriteString(downgradeSGR("38;5;"+strconv.Itoa(c), p.depth))
+	b.WriteByte('m')
+	b.Write(v)
+	b.WriteString("\x1b[m")
+	emitUnitSep(b)
+}
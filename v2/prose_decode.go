@@ -0,0 +1,271 @@
+package zlog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ProseRecord is a record decoded from prose output. See [ProseDecoder].
+type ProseRecord struct {
+	// Time is the time of the record, or the zero Time if it wasn't shown.
+	Time time.Time
+	// Source is the source of the record, as shown: either a function or a
+	// file and line. It's empty if it wasn't shown.
+	Source string
+	// Message is the message of the record, with any continuation lines
+	// unindented.
+	Message string
+	// Attrs are the Attrs of the record, with dotted keys turned back into
+	// groups.
+	Attrs []slog.Attr
+	// Level is the level of the record.
+	Level slog.Level
+}
+
+// ProseDecoder reads records in the prose format, as written by a Handler
+// with [Options.ProseFormat] set, from a stream.
+//
+// Escape sequences, including colors and hyperlinks, are removed, so output
+// captured from a terminal can be decoded. Because the layout of the header
+// is configurable, its fields are recognized by their contents: the level and
+// time by their formats, the message as the last field, and the source as any
+// remaining field. Layouts must put "{msg}" last for records to be decoded
+// correctly, and literal text without letters or digits is ignored.
+//
+// Because an empty message isn't shown, a last field that looks like a
+// timestamp is taken as the time, unless the header already has one. A
+// message that is itself a timestamp is lost if the time isn't shown.
+//
+// Values are decoded as strings if quoted, and as bools, integers, or floats
+// if they look like one. Other values, such as errors and durations, are
+// decoded as strings. Characters that were replaced when the record was
+// written are not restored.
+type ProseDecoder struct {
+	r *bufio.Reader
+	// Last is the time of the last record, for completing times shown
+	// without a date.
+	last time.Time
+}
+
+// NewProseDecoder returns a ProseDecoder reading from "r".
+func NewProseDecoder(r io.Reader) *ProseDecoder {
+	return &ProseDecoder{r: bufio.NewReader(r)}
+}
+
+// ErrProseSyntax is returned by [ProseDecoder.Decode] for a record that
+// doesn't have the structure of a prose record.
+var ErrProseSyntax = errors.New("zlog: invalid prose record")
+
+// Decode returns the next record. It returns [io.EOF] when there are no more
+// records.
+//
+// If a record can't be decoded, an error wrapping [ErrProseSyntax] is
+// returned, and the next call to Decode continues with the following record.
+func (d *ProseDecoder) Decode() (ProseRecord, error) {
+	var rec ProseRecord
+	b, err := d.r.ReadBytes(0x1e)
+	switch {
+	case errors.Is(err, io.EOF) && len(bytes.TrimSpace(appendStripped(nil, b))) == 0:
+		return rec, io.EOF
+	case errors.Is(err, io.EOF):
+		return rec, io.ErrUnexpectedEOF
+	case err != nil:
+		return rec, err
+	}
+	// Consume the newline after the separator, which may have been
+	// translated by a terminal.
+	for _, c := range []byte{'\r', '\n'} {
+		if n, err := d.r.Peek(1); err == nil && n[0] == c {
+			d.r.ReadByte()
+		}
+	}
+
+	b = appendStripped(nil, b[:len(b)-1])
+	b = bytes.ReplaceAll(b, []byte{'\r'}, nil)
+	head, attrs, ok := bytes.Cut(b, []byte{0x1d})
+	if !ok {
+		return rec, fmt.Errorf("%w: no ␝", ErrProseSyntax)
+	}
+	d.header(&rec, head)
+	rec.Attrs, err = decodeProseAttrs(attrs)
+	if err != nil {
+		return rec, fmt.Errorf("%w: %v", ErrProseSyntax, err)
+	}
+	return rec, nil
+}
+
+// Header decodes the fields in "b" into "rec".
+func (d *ProseDecoder) header(rec *ProseRecord, b []byte) {
+	var fs []string
+	var punct string
+	for _, f := range bytes.Split(b, []byte{0x1f}) {
+		f := strings.TrimSpace(string(f))
+		if strings.IndexFunc(f, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) == -1 {
+			// Empty or only literal punctuation, unless it's all there is.
+			if f != "" {
+				punct = f
+			}
+			continue
+		}
+		fs = append(fs, f)
+	}
+	if len(fs) == 0 && punct != "" {
+		fs = append(fs, punct)
+	}
+	level, timed := false, false
+	for i, f := range fs {
+		if i == len(fs)-1 && (i == 0 || timed || !d.isTime(f)) {
+			// An empty message isn't shown, so the last field is only
+			// the message if it can't be the time.
+			rec.Message = strings.ReplaceAll(f, "\n"+proseIndent, "\n")
+			break
+		}
+		if !level {
+			if err := rec.Level.UnmarshalText([]byte(strings.Trim(f, "[]():|"))); err == nil {
+				level = true
+				continue
+			}
+		}
+		if t, ok := d.time(f); ok {
+			rec.Time = t
+			timed = true
+			continue
+		}
+		if len(f) > 1 && f[0] == '+' {
+			if _, err := time.ParseDuration(f[1:]); err == nil {
+				timed = true
+				continue // The delta field.
+			}
+		}
+		if rec.Source == "" {
+			rec.Source = f
+		}
+	}
+}
+
+// IsTime reports whether "s" looks like a timestamp or a delta.
+func (d *ProseDecoder) isTime(s string) bool {
+	if len(s) > 1 && s[0] == '+' {
+		_, err := time.ParseDuration(s[1:])
+		return err == nil
+	}
+	_, err := time.Parse(time.RFC3339Nano, s)
+	return err == nil
+}
+
+// Time parses a timestamp as written in prose output.
+func (d *ProseDecoder) time(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		d.last = t
+		return t, true
+	}
+	t, err := time.ParseInLocation("15:04:05.999999999", s, d.last.Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+	y, m, day := d.last.Date()
+	t = time.Date(y, m, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	d.last = t
+	return t, true
+}
+
+// DecodeProseAttrs decodes the Attrs in "b", turning dotted keys into groups.
+func decodeProseAttrs(b []byte) ([]slog.Attr, error) {
+	var root proseNode
+	for _, f := range bytes.Split(b, []byte{0x1f}) {
+		f = bytes.TrimLeft(f, " \n")
+		if len(f) == 0 {
+			continue
+		}
+		k, v, ok := strings.Cut(string(f), "=")
+		if !ok {
+			return nil, fmt.Errorf(`no "=" in %q`, f)
+		}
+		val, err := decodeProseValue(v)
+		if err != nil {
+			return nil, err
+		}
+		root.Add(strings.Split(k, "."), val)
+	}
+	return root.Attrs(), nil
+}
+
+// DecodeProseValue decodes a single value.
+func decodeProseValue(v string) (slog.Value, error) {
+	if strings.HasPrefix(v, "\n") { // An expanded value.
+		v = strings.TrimSuffix(strings.TrimPrefix(v, "\n"+proseIndent), "\n")
+		return slog.StringValue(strings.ReplaceAll(v, "\n"+proseIndent, "\n")), nil
+	}
+	v = strings.TrimRight(v, " \n")
+	if strings.HasPrefix(v, `"`) {
+		s, err := strconv.Unquote(v)
+		if err != nil {
+			return slog.Value{}, err
+		}
+		return slog.StringValue(s), nil
+	}
+	if b, err := strconv.ParseBool(v); err == nil && (v == "true" || v == "false") {
+		return slog.BoolValue(b), nil
+	}
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return slog.Int64Value(i), nil
+	}
+	if u, err := strconv.ParseUint(v, 10, 64); err == nil {
+		return slog.Uint64Value(u), nil
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return slog.Float64Value(f), nil
+	}
+	return slog.StringValue(v), nil
+}
+
+// ProseNode is used to reassemble groups from dotted keys.
+type proseNode struct {
+	key  string
+	val  slog.Value
+	kids []*proseNode
+}
+
+// Add adds the value "v" at the path "ks".
+func (n *proseNode) Add(ks []string, v slog.Value) {
+	if len(ks) == 1 {
+		n.kids = append(n.kids, &proseNode{key: ks[0], val: v})
+		return
+	}
+	var g *proseNode
+	for _, k := range n.kids {
+		if k.key == ks[0] && k.kids != nil {
+			g = k
+			break
+		}
+	}
+	if g == nil {
+		g = &proseNode{key: ks[0], kids: []*proseNode{}}
+		n.kids = append(n.kids, g)
+	}
+	g.Add(ks[1:], v)
+}
+
+// Attrs returns the children of "n" as Attrs.
+func (n *proseNode) Attrs() []slog.Attr {
+	if len(n.kids) == 0 {
+		return nil
+	}
+	as := make([]slog.Attr, len(n.kids))
+	for i, k := range n.kids {
+		if k.kids != nil {
+			as[i] = slog.Attr{Key: k.key, Value: slog.GroupValue(k.Attrs()...)}
+			continue
+		}
+		as[i] = slog.Attr{Key: k.key, Value: k.val}
+	}
+	return as
+}
@@ -0,0 +1,84 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultExpandWidth is the length above which values are put on
+// continuation lines in expanded prose output, if [ProseOptions.ExpandWidth]
+// is unset.
+const DefaultExpandWidth = 80
+
+// ProseIndent is the indentation for continuation lines.
+const proseIndent = "    "
+
+// ProseExpander puts long or multi-line values on continuation lines, if
+// configured.
+//
+// All methods are OK to call on a nil receiver, and will result in nothing
+// being expanded.
+type proseExpander struct {
+	p      *ansiPrinter
+	width  int
+	pretty bool
+}
+
+// NewProseExpander returns a proseExpander configured by "po", or nil if
+// expanded output isn't configured.
+func newProseExpander(p *ansiPrinter, po *ProseOptions) *proseExpander {
+	if !po.Expanded {
+		return nil
+	}
+	x := &proseExpander{
+		p:      p,
+		width:  po.ExpandWidth,
+		pretty: po.PrettyJSON,
+	}
+	if x.width <= 0 {
+		x.width = DefaultExpandWidth
+	}
+	return x
+}
+
+// Block writes "v" on indented continuation lines with the formatting "i",
+// if it's long or contains a newline. It reports whether it wrote anything.
+func (x *proseExpander) Block(b *buffer, v string, i int) bool {
+	if x == nil || (utf8.RuneCountInString(v) <= x.width && strings.IndexByte(v, '\n') == -1) {
+		return false
+	}
+	for _, l := range strings.Split(strings.TrimRight(v, "\n"), "\n") {
+		b.WriteByte('\n')
+		b.WriteString(proseIndent)
+		reset := x.p.emitEscape(b, i)
+		b.WriteString(sanitize(l))
+		reset()
+	}
+	b.WriteByte('\n')
+	return true
+}
+
+// JSON writes the JSON "t" on continuation lines, pretty-printing it if
+// configured. It reports whether it wrote anything.
+func (x *proseExpander) JSON(b *buffer, t []byte) bool {
+	if x == nil {
+		return false
+	}
+	if x.pretty {
+		var out bytes.Buffer
+		if err := json.Indent(&out, t, "", "  "); err == nil && out.Len() > 2 {
+			t = out.Bytes()
+		}
+	}
+	return x.Block(b, string(t), printJSON)
+}
+
+// Message returns "msg" with any continuation lines indented.
+func (x *proseExpander) Message(msg string) string {
+	if x == nil || strings.IndexByte(msg, '\n') == -1 {
+		return msg
+	}
+	return strings.ReplaceAll(strings.TrimRight(msg, "\n"), "\n", "\n"+proseIndent)
+}
@@ -0,0 +1,392 @@
+package zlog
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultProseLayout is the layout used for prose output if
+// [ProseOptions.Layout] is unset.
+const DefaultProseLayout = `{level:5} {source} {time} {msg} {attrs}`
+
+// ProseOptions configures the prose output. See [Options.Prose].
+//
+// The zero value is the default configuration.
+type ProseOptions struct {
+	// Layout controls the order and presence of the fields of a line. See the
+	// "Prose output" section of the package documentation for the syntax.
+	//
+	// If unset, [DefaultProseLayout] is used.
+	Layout string
+	// Baggage controls how the OpenTelemetry baggage group is shown.
+	Baggage GroupStyle
+	// Goroutine controls how the pprof label group is shown.
+	Goroutine GroupStyle
+	// LocalTime causes timestamps to be shown in the local time zone instead
+	// of UTC.
+	LocalTime bool
+	// TimePrecision is the precision of the timestamps: [time.Millisecond],
+	// [time.Microsecond], or [time.Nanosecond]. Other values are rounded down
+	// to one of these.
+	//
+	// If unset, timestamps are shown with second precision.
+	TimePrecision time.Duration
+	// TimeOnly causes the date to be omitted from a timestamp if the previous
+	// record was on the same day.
+	//
+	// As records are written as they come, whether all of them fall on the
+	// same day isn't known. Instead, the first record and the first record of
+	// every new day show the full timestamp, so the date of any record can be
+	// found by looking back.
+	TimeOnly bool
+	// Expanded causes values that are long or contain newlines, such as stack
+	// traces or SQL, to be put on indented continuation lines instead of
+	// being quoted. Continuation lines of multi-line messages are indented.
+	//
+	// Records are still terminated by a ␞ and a newline.
+	Expanded bool
+	// ExpandWidth is the length above which values are put on continuation
+	// lines in expanded output.
+	//
+	// If unset, [DefaultExpandWidth] is used.
+	ExpandWidth int
+	// PrettyJSON causes the output of [encoding/json.Marshaler] values to be
+	// indented in expanded output.
+	PrettyJSON bool
+	// SourceLocation causes the source to be shown as the file's directory,
+	// name, and line, like "zlog/handler.go:42", instead of the function.
+	SourceLocation bool
+	// SourceURL is a template for the target of the hyperlink on the source,
+	// when hyperlinks are used. The placeholders "{path}", "{relpath}",
+	// "{line}", "{revision}", and "{host}" are replaced with the absolute path
+	// of the file, the path relative to SourceRoot, the line number, the VCS
+	// revision from the build information, and the host name. For example:
+	//
+	//	vscode://file{path}:{line}
+	//	https://github.com/quay/zlog/blob/{revision}/{relpath}#L{line}
+	//
+	// If unset, a "file://" URI is used.
+	SourceURL string
+	// SourceRoot is the directory that "{relpath}" is relative to. For
+	// binaries built with "-trimpath", paths are relative to the main module
+	// instead.
+	//
+	// If unset, the working directory is used.
+	SourceRoot string
+	// CorrelationKeys are the keys, including any group prefix like
+	// "baggage.tenant", whose values are shown in a color derived from the
+	// value when colors are used. Records that share a value, such as a
+	// request ID, then share a color.
+	CorrelationKeys []string
+	// Compact disables the alignment and wrapping used when writing to a
+	// terminal.
+	//
+	// When writing to a terminal, the Attrs are aligned into a column and
+	// lines longer than the terminal's width are wrapped with a hanging
+	// indent. Other outputs always use the compact form.
+	Compact bool
+}
+
+// GroupStyle controls how one of the contextual groups is shown in prose
+// output.
+type GroupStyle int
+
+// These are the available GroupStyle values.
+const (
+	// GroupPrefixed shows the group's values as Attrs with the group's name
+	// as a prefix, like "baggage.key=value".
+	GroupPrefixed GroupStyle = iota
+	// GroupUnprefixed shows the group's values as Attrs without a prefix,
+	// like "key=value".
+	GroupUnprefixed
+	// GroupHidden omits the group.
+	GroupHidden
+)
+
+// These are the fields that can be used in a layout.
+const (
+	layoutLiteral = iota
+	layoutLevel
+	layoutSource
+	layoutTime
+	layoutMessage
+	layoutDelta
+	layoutSize
+)
+
+// LayoutNames maps the names used in a layout to the fields.
+var layoutNames = map[string]int{
+	"level":  layoutLevel,
+	"source": layoutSource,
+	"time":   layoutTime,
+	"msg":    layoutMessage,
+	"delta":  layoutDelta,
+}
+
+// ProseLayout is a parsed layout.
+type proseLayout struct {
+	// Header is the series of fields and literals before the Attrs.
+	header []layoutPart
+	// Width is the configured width of each field, or 0.
+	width [layoutSize]int
+	// Attrs reports whether the Attrs are shown.
+	attrs bool
+}
+
+// LayoutPart is a field or literal text in a layout.
+type layoutPart struct {
+	lit   string
+	field int
+}
+
+// ParseLayout parses the layout "s".
+//
+// Fields are written as "{name}" or "{name:width}". The "{attrs}" field must
+// be last, and anything after it is ignored. Unrecognized fields are treated
+// as literal text.
+func parseLayout(s string) *proseLayout {
+	l := new(proseLayout)
+	lit := func(s string) {
+		if s == "" {
+			return
+		}
+		if n := len(l.header); n != 0 && l.header[n-1].field == layoutLiteral {
+			l.header[n-1].lit += s
+			return
+		}
+		l.header = append(l.header, layoutPart{lit: s})
+	}
+	for len(s) != 0 {
+		i := strings.IndexByte(s, '{')
+		if i == -1 {
+			lit(s)
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j == -1 {
+			lit(s)
+			break
+		}
+		lit(s[:i])
+		spec := s[i+1 : i+j]
+		name, width, _ := strings.Cut(spec, ":")
+		w, err := strconv.Atoi(width)
+		if width != "" && (err != nil || w < 0) {
+			lit(s[i : i+j+1])
+			s = s[i+j+1:]
+			continue
+		}
+		if name == "attrs" {
+			l.attrs = true
+			break
+		}
+		f, ok := layoutNames[name]
+		if !ok {
+			lit(s[i : i+j+1])
+			s = s[i+j+1:]
+			continue
+		}
+		l.width[f] = w
+		l.header = append(l.header, layoutPart{field: f})
+		s = s[i+j+1:]
+	}
+	// The separator before the Attrs is always written, so trailing space
+	// would be doubled.
+	if n := len(l.header); n != 0 && l.header[n-1].field == layoutLiteral {
+		t := strings.TrimRightFunc(l.header[n-1].lit, unicode.IsSpace)
+		if t == "" {
+			l.header = l.header[:n-1]
+		} else {
+			l.header[n-1].lit = t
+		}
+	}
+	return l
+}
+
+// Render writes the header fields captured in "s" according to the layout,
+// followed by the separator for the Attrs.
+//
+// Fields that aren't present are skipped along with the literal text around
+// them: text up to the first whitespace in a literal belongs to the field
+// before it, and text after that belongs to the field after it. Literals at
+// the start or end belong entirely to the one field next to them, and
+// literals without whitespace are only written between two present fields.
+func (l *proseLayout) Render(b *buffer, s *stateProse) {
+	present := func(i int) bool {
+		return i >= 0 && i < len(l.header) && len(s.fields[l.header[i].field].b) != 0
+	}
+	start := len(*b)
+	for i, p := range l.header {
+		if p.field != layoutLiteral {
+			if !present(i) {
+				continue
+			}
+			f := &s.fields[p.field]
+			b.Write(f.b)
+			if pad := l.width[p.field] - f.width; pad > 0 {
+				b.WriteString(strings.Repeat(" ", pad))
+			}
+			continue
+		}
+		suffix, ws, prefix := splitLiteral(p.lit)
+		switch {
+		case i == len(l.header)-1: // All of it belongs to the previous field.
+			if present(i - 1) {
+				writeLayoutLiteral(b, p.lit)
+			}
+		case i == 0: // All of it belongs to the next field.
+			if present(i + 1) {
+				writeLayoutLiteral(b, p.lit)
+			}
+		case ws == "": // All of it belongs to the previous field.
+			if present(i-1) && present(i+1) {
+				writeLayoutLiteral(b, p.lit)
+			}
+		default:
+			if present(i - 1) {
+				writeLayoutLiteral(b, suffix)
+			}
+			if len(*b) != start && present(i+1) {
+				writeLayoutLiteral(b, ws)
+			}
+			if present(i + 1) {
+				writeLayoutLiteral(b, prefix)
+			}
+		}
+	}
+	emitGroupSep(b)
+}
+
+// SplitLiteral splits "lit" around its first run of whitespace.
+func splitLiteral(lit string) (suffix, ws, prefix string) {
+	i := strings.IndexFunc(lit, unicode.IsSpace)
+	if i == -1 {
+		return "", "", lit
+	}
+	rest := strings.TrimLeftFunc(lit[i:], unicode.IsSpace)
+	j := len(lit) - len(rest)
+	return lit[:i], lit[i:j], rest
+}
+
+// WriteLayoutLiteral writes "lit", putting a unit separator before every run
+// of whitespace, so that the columns can still be split apart.
+func writeLayoutLiteral(b *buffer, lit string) {
+	space := false
+	for _, r := range lit {
+		isSpace := unicode.IsSpace(r)
+		if isSpace && !space {
+			b.WriteByte(0x1f)
+		}
+		space = isSpace
+		*b = utf8.AppendRune(*b, r)
+	}
+}
+
+// ProseField is a captured header field.
+type proseField struct {
+	b buffer
+	// Width is the number of columns the field takes up, excluding any escape
+	// sequences.
+	width int
+}
+
+// Capture sets the field to the output of "fn".
+func (f *proseField) Capture(fn func(*buffer)) {
+	fn(&f.b)
+	f.width = visibleWidth(f.b)
+}
+
+// VisibleWidth reports the number of runes in "b", excluding ANSI escape
+// sequences and other control characters.
+func visibleWidth(b []byte) (n int) {
+	for i := 0; i < len(b); {
+		if l := escapeLen(b[i:]); l != 0 {
+			i += l
+			continue
+		}
+		if b[i] < 0x20 { // Other control characters take no space.
+			i++
+			continue
+		}
+		_, sz := utf8.DecodeRune(b[i:])
+		i += sz
+		n++
+	}
+	return n
+}
+
+// AppendStripped appends "b" to "dst" without any ANSI escape sequences.
+func appendStripped(dst, b []byte) []byte {
+	for i := 0; i < len(b); {
+		if l := escapeLen(b[i:]); l != 0 {
+			i += l
+			continue
+		}
+		dst = append(dst, b[i])
+		i++
+	}
+	return dst
+}
+
+// EscapeLen reports the length of the ANSI escape sequence at the start of
+// "b", or 0 if "b" doesn't start with one.
+func escapeLen(b []byte) int {
+	if len(b) < 2 || b[0] != 0x1b {
+		return 0
+	}
+	i := 2
+	switch b[1] {
+	case '[': // CSI: ends with a byte in the range 0x40–0x7E.
+		for i < len(b) && (b[i] < 0x40 || b[i] > 0x7e) {
+			i++
+		}
+		return min(i+1, len(b))
+	case ']': // OSC: ends with BEL or ST.
+		for i < len(b) {
+			if b[i] == 0x07 {
+				return i + 1
+			}
+			if b[i] == 0x1b && i+1 < len(b) && b[i+1] == '\\' {
+				return i + 2
+			}
+			i++
+		}
+		return i
+	default:
+		return 2
+	}
+}
+
+// TruncateLeft shortens "s" to "n" columns by removing runes from the start,
+// if needed.
+func truncateLeft(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	rs := []rune(s)
+	return "…" + string(rs[len(rs)-n+1:])
+}
+
+// StateProse is the state needed to construct a prose-format log message.
+type stateProse struct {
+	stateJournal
+	fields [layoutSize]proseField
+	// AttrsAt is the offset of the Attrs in the buffer.
+	attrsAt int
+	// CorrAt is the offset of the value of a correlation key in the buffer,
+	// or 0.
+	corrAt int
+}
+
+// Reset implements state.
+func (s *stateProse) Reset(g []string, prefmt *buffer) {
+	s.stateJournal.Reset(g, prefmt)
+	s.corrAt = 0
+	for i := range s.fields {
+		s.fields[i].b = s.fields[i].b[:0]
+		s.fields[i].width = 0
+	}
+}
@@ -0,0 +1,64 @@
+package zlog
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Sanitize returns "s" with the characters that a terminal could interpret,
+// or that would be confused with the prose separators, replaced with visible
+// representations. Newlines and tabs are kept.
+//
+// C0 control characters are shown as the matching [Control Pictures], like
+// "␛" for ESC, C1 control characters are shown as Go escapes, like "\u009b",
+// and invalid UTF-8 is shown as U+FFFD.
+//
+// [Control Pictures]: https://www.unicode.org/charts/PDF/U2400.pdf
+func sanitize(s string) string {
+	if !needsSanitize(s) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s) + 8)
+	for i := 0; i < len(s); {
+		r, sz := utf8.DecodeRuneInString(s[i:])
+		i += sz
+		switch {
+		case r == '\n', r == '\t':
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteRune(0x2400 + r)
+		case r == 0x7f:
+			b.WriteRune('␡')
+		case r >= 0x80 && r < 0xa0:
+			b.WriteString(`\u00`)
+			b.WriteByte(hexChar[r>>4])
+			b.WriteByte(hexChar[r&0xf])
+		default: // Includes utf8.RuneError for invalid input.
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// NeedsSanitize reports whether "s" has any characters that [sanitize]
+// replaces.
+func needsSanitize(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\n', c == '\t':
+		case c < 0x20, c == 0x7f:
+			return true
+		case c >= utf8.RuneSelf:
+			// Check the rest of the string rune-wise.
+			for _, r := range s[i:] {
+				if r == utf8.RuneError || r < 0x20 && r != '\n' && r != '\t' || r >= 0x7f && r < 0xa0 {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package zlog
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ProseSource formats the source location for prose output, according to the
+// [ProseOptions].
+type proseSource struct {
+	fileLine bool
+	// Link is the parsed hyperlink template, or nil for file:// URIs.
+	link []linkPart
+	root string
+}
+
+// LinkPart is a piece of a hyperlink template: literal text, or one of the
+// per-record placeholders.
+type linkPart struct {
+	text string
+	// Field is the placeholder, like "{line}", or empty for literal text.
+	field string
+}
+
+// NewProseSource returns a proseSource configured by "po".
+func newProseSource(po *ProseOptions) *proseSource {
+	ps := &proseSource{
+		fileLine: po.SourceLocation,
+		root:     po.SourceRoot,
+	}
+	if ps.root == "" {
+		ps.root, _ = os.Getwd()
+	}
+	if po.SourceURL != "" {
+		ps.link = parseLinkTemplate(po.SourceURL)
+	}
+	return ps
+}
+
+// ParseLinkTemplate splits the hyperlink template "t" into parts, filling in
+// the placeholders that are the same for every record.
+func parseLinkTemplate(t string) []linkPart {
+	t = strings.NewReplacer(
+		"{revision}", buildInfo().Revision,
+		"{host}", hostname(),
+	).Replace(t)
+	var out []linkPart
+	for t != "" {
+		i, field := -1, ""
+		for _, f := range []string{"{path}", "{relpath}", "{line}"} {
+			if j := strings.Index(t, f); j != -1 && (i == -1 || j < i) {
+				i, field = j, f
+			}
+		}
+		if i == -1 {
+			out = append(out, linkPart{text: t})
+			break
+		}
+		if i != 0 {
+			out = append(out, linkPart{text: t[:i]})
+		}
+		out = append(out, linkPart{field: field})
+		t = t[i+len(field):]
+	}
+	return out
+}
+
+// Write writes the source "f", shortened to "width" if non-zero, as a
+// hyperlink if "p" is non-nil.
+func (ps *proseSource) Write(b *buffer, p *ansiPrinter, f *runtime.Frame, width int) {
+	text := f.Function
+	if (ps.fileLine || text == "") && f.File != "" {
+		text = shortFile(f.File) + ":" + strconv.Itoa(f.Line)
+	}
+	if f.File != "" && p.Linking() {
+		defer p.Link(b, ps.URL(f))()
+	}
+	defer p.Source(b)()
+	b.WriteString(truncateLeft(text, width))
+}
+
+// URL returns the hyperlink target for "f".
+func (ps *proseSource) URL(f *runtime.Frame) string {
+	if ps.link == nil {
+		u := url.URL{Scheme: "file", Host: hostname(), Path: filepath.ToSlash(f.File)}
+		return u.String()
+	}
+	var out strings.Builder
+	for _, part := range ps.link {
+		switch part.field {
+		case "":
+			out.WriteString(part.text)
+		case "{path}":
+			out.WriteString(f.File)
+		case "{relpath}":
+			out.WriteString(filepath.ToSlash(ps.rel(f.File)))
+		case "{line}":
+			out.WriteString(strconv.Itoa(f.Line))
+		}
+	}
+	return out.String()
+}
+
+// Rel returns "file" relative to the module or source root, if possible.
+func (ps *proseSource) rel(file string) string {
+	bi := buildInfo()
+	switch {
+	case bi.Module != "" && strings.HasPrefix(file, bi.Module+"/"): // Built with -trimpath.
+		return strings.TrimPrefix(file, bi.Module+"/")
+	case ps.root != "":
+		if r, err := filepath.Rel(ps.root, file); err == nil && !strings.HasPrefix(r, "..") {
+			return r
+		}
+	}
+	return file
+}
+
+// ShortFile returns the last directory and file name of "path".
+func shortFile(path string) string {
+	dir, file := filepath.Split(path)
+	return filepath.Join(filepath.Base(dir), file)
+}
+
+// Hostname is the host name used in file:// URIs.
+var hostname = sync.OnceValue(func() string {
+	h, _ := os.Hostname()
+	return h
+})
+
+// BuildInfo is the information used for source hyperlink templates.
+var buildInfo = sync.OnceValue(func() (out struct{ Module, Revision string }) {
+	out.Revision = "HEAD"
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return out
+	}
+	out.Module = bi.Main.Path
+	if v := bi.Main.Version; v != "" && v != "(devel)" {
+		out.Revision = v
+	}
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" && s.Value != "" {
+			out.Revision = s.Value
+		}
+	}
+	return out
+})
@@ -0,0 +1,230 @@
+package zlog
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ColorDepth is the number of colors a terminal supports.
+type colorDepth int
+
+// These are the supported color depths.
+const (
+	colorNone colorDepth = iota
+	color16
+	color256
+	colorTrue
+)
+
+// TermCaps is the set of capabilities of a terminal that prose output uses.
+type termCaps struct {
+	depth colorDepth
+	links bool
+}
+
+// DetectTerm reports the capabilities of the terminal described by the
+// environment, using "getenv" to look up variables. The "tty" argument
+// reports whether the output is a terminal.
+//
+// The "FORCE_COLOR" and "CLICOLOR_FORCE" variables enable colors regardless
+// of "tty" and "NO_COLOR", and "FORCE_HYPERLINK" does the same for
+// hyperlinks.
+func detectTerm(getenv func(string) string, tty bool) termCaps {
+	var c termCaps
+	term := getenv("TERM")
+	force := false
+	switch v := getenv("FORCE_COLOR"); v {
+	case "":
+	case "0", "false":
+		return c
+	case "2":
+		c.depth = color256
+		force = true
+	case "3":
+		c.depth = colorTrue
+		force = true
+	default:
+		force = true
+	}
+	if !force {
+		switch {
+		case getenv("NO_COLOR") != "":
+			return c
+		case getenv("CLICOLOR_FORCE") != "" && getenv("CLICOLOR_FORCE") != "0":
+		case !tty, term == "dumb":
+			return c
+		}
+	}
+	c.depth = max(c.depth, detectDepth(getenv, term))
+	c.links = detectLinks(getenv, term)
+	switch v := getenv("FORCE_HYPERLINK"); v {
+	case "":
+	case "0", "false":
+		c.links = false
+	default:
+		c.links = true
+	}
+	return c
+}
+
+// DetectDepth reports the color depth of the terminal.
+func detectDepth(getenv func(string) string, term string) colorDepth {
+	switch strings.ToLower(getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return colorTrue
+	}
+	switch getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm", "vscode", "ghostty":
+		return colorTrue
+	case "Apple_Terminal":
+		return color256
+	}
+	switch {
+	case getenv("WT_SESSION") != "":
+		return colorTrue
+	case strings.HasSuffix(term, "-direct"), strings.Contains(term, "truecolor"), strings.Contains(term, "24bit"):
+		return colorTrue
+	case strings.Contains(term, "256color"):
+		return color256
+	}
+	return color16
+}
+
+// DetectLinks reports whether the terminal supports OSC-8 hyperlinks.
+//
+// Terminals are assumed to support them, as unsupporting terminals should
+// ignore unknown OSC sequences, except for those known to print them.
+func detectLinks(getenv func(string) string, term string) bool {
+	if getenv("TERM_PROGRAM") == "tmux" {
+		// Hyperlinks are passed through starting with tmux 3.4.
+		major, minor, _ := strings.Cut(getenv("TERM_PROGRAM_VERSION"), ".")
+		a, _ := strconv.Atoi(major)
+		b, _ := strconv.Atoi(strings.TrimRight(minor, "abcdefghijklmnopqrstuvwxyz-"))
+		return a > 3 || (a == 3 && b >= 4)
+	}
+	switch {
+	case term == "linux":
+		return false
+	case strings.HasPrefix(term, "screen"), strings.HasPrefix(term, "tmux"):
+		return false
+	case getenv("TMUX") != "":
+		return false
+	}
+	return true
+}
+
+// Limit restricts the printer to the capabilities "c".
+func (p *ansiPrinter) limit(c termCaps) {
+	p.depth = c.depth
+	p.links = c.links
+	for i, s := range p.sgr {
+		p.sgr[i] = downgradeSGR(s, c.depth)
+	}
+}
+
+// DowngradeSGR rewrites the extended colors in the SGR parameters "s" to fit
+// in the color depth "d".
+func downgradeSGR(s string, d colorDepth) string {
+	if d == colorTrue || s == "" {
+		return s
+	}
+	ps := strings.Split(s, ";")
+	out := make([]string, 0, len(ps))
+	for i := 0; i < len(ps); i++ {
+		p := ps[i]
+		if (p != "38" && p != "48") || i+1 == len(ps) {
+			out = append(out, p)
+			continue
+		}
+		var n int
+		switch ps[i+1] {
+		case "5":
+			if i+2 >= len(ps) {
+				return strings.Join(out, ";")
+			}
+			n, _ = strconv.Atoi(ps[i+2])
+			i += 2
+		case "2":
+			if i+4 >= len(ps) {
+				return strings.Join(out, ";")
+			}
+			var rgb [3]int
+			for j := range rgb {
+				rgb[j], _ = strconv.Atoi(ps[i+2+j])
+			}
+			i += 4
+			n = rgbTo256(rgb)
+		default:
+			out = append(out, p)
+			continue
+		}
+		if d == color256 {
+			out = append(out, p, "5", strconv.Itoa(n))
+			continue
+		}
+		base := 30
+		if p == "48" {
+			base = 40
+		}
+		c := to16(n)
+		if c >= 8 {
+			base += 60
+			c -= 8
+		}
+		out = append(out, strconv.Itoa(base+c))
+	}
+	return strings.Join(out, ";")
+}
+
+// RgbTo256 returns the nearest color in the 6×6×6 cube of the 256-color
+// palette.
+func rgbTo256(rgb [3]int) int {
+	n := 16
+	for i, v := range rgb {
+		var c int
+		switch {
+		case v < 48:
+			c = 0
+		case v < 115:
+			c = 1
+		default:
+			c = min((v-35)/40, 5)
+		}
+		n += c * []int{36, 6, 1}[i]
+	}
+	return n
+}
+
+// To16 returns the nearest of the 16 basic colors to the 256-color palette
+// entry "n".
+func to16(n int) int {
+	switch {
+	case n < 16:
+		return n
+	case n >= 232: // Grayscale ramp.
+		switch l := n - 232; {
+		case l < 5:
+			return 0
+		case l < 12:
+			return 8
+		case l < 21:
+			return 7
+		default:
+			return 15
+		}
+	}
+	n -= 16
+	r, g, b := n/36, n/6%6, n%6
+	m := max(r, g, b)
+	c := 0
+	for i, v := range []int{r, g, b} {
+		// Keep the components that are at least half of the brightest.
+		if m != 0 && v*2 > m {
+			c |= 1 << i
+		}
+	}
+	if m >= 4 {
+		c += 8
+	}
+	return c
+}
@@ -0,0 +1,776 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+func TestProseLayout(t *testing.T) {
+	m, err := baggage.NewMember("tenant", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bg, err := baggage.New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := baggage.ContextWithBaggage(context.Background(), bg)
+
+	tt := []struct {
+		Name string
+		Opts ProseOptions
+		Want string
+	}{
+		{
+			Name: "Default",
+			Want: "INFO \x1f msg\x1d baggage.tenant=\"example\"\x1f a=1\x1f\x1e\n",
+		},
+		{
+			Name: "Reordered",
+			Opts: ProseOptions{Layout: "{time} [{level}]: {msg} -- {attrs}"},
+			Want: "[INFO]:\x1f msg\x1f --\x1d baggage.tenant=\"example\"\x1f a=1\x1f\x1e\n",
+		},
+		{
+			Name: "NoAttrs",
+			Opts: ProseOptions{Layout: "{level:7}|{msg}"},
+			Want: "INFO   |msg\x1d\x1e\n",
+		},
+		{
+			Name: "MessageOnly",
+			Opts: ProseOptions{Layout: "{msg} {attrs}"},
+			Want: "msg\x1d baggage.tenant=\"example\"\x1f a=1\x1f\x1e\n",
+		},
+		{
+			Name: "Unknown",
+			Opts: ProseOptions{Layout: "{level} {bogus} {msg} {attrs}"},
+			Want: "INFO\x1f {bogus}\x1f msg\x1d baggage.tenant=\"example\"\x1f a=1\x1f\x1e\n",
+		},
+		{
+			Name: "BaggageUnprefixed",
+			Opts: ProseOptions{Baggage: GroupUnprefixed},
+			Want: "INFO \x1f msg\x1d tenant=\"example\"\x1f a=1\x1f\x1e\n",
+		},
+		{
+			Name: "BaggageHidden",
+			Opts: ProseOptions{Baggage: GroupHidden},
+			Want: "INFO \x1f msg\x1d a=1\x1f\x1e\n",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := tc.Opts
+			h := proseHandler(&buf, &Options{
+				OmitSource: true,
+				OmitTime:   true,
+				Baggage:    func(string) bool { return true },
+				Prose:      &opts,
+			})
+			slog.New(h).InfoContext(ctx, "msg", "a", 1)
+			if got := buf.String(); got != tc.Want {
+				t.Errorf("got: %q, want: %q", got, tc.Want)
+			}
+		})
+	}
+}
+
+func TestProseLayoutWidth(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &Options{
+		Level:    LevelEverything,
+		OmitTime: true,
+		Prose:    &ProseOptions{Layout: "{source:8} {level:7} {msg}"},
+	}
+	log := slog.New(proseHandler(&buf, opts))
+	log.Debug("one")
+	log.Log(context.Background(), slog.LevelDebug-4, "two")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got: %q", lines)
+	}
+	for i, want := range []string{
+		"DEBUG  \x1f one\x1d\x1e",
+		"DEBUG-4\x1f two\x1d\x1e",
+	} {
+		src, rest, ok := strings.Cut(lines[i], "\x1f ")
+		if !ok {
+			t.Fatalf("no separator: %q", lines[i])
+		}
+		if got := []rune(src); len(got) != 8 || got[0] != '…' {
+			t.Errorf("unexpected source: %q", src)
+		}
+		if rest != want {
+			t.Errorf("got: %q, want: %q", rest, want)
+		}
+	}
+}
+
+func TestProseTime(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2009, time.November, 10, 23, 59, 59, 250_000_000, time.UTC)
+	times := []time.Time{
+		base,
+		base.Add(500 * time.Millisecond),
+		base.Add(1500 * time.Millisecond),
+	}
+	tt := []struct {
+		Name string
+		Opts ProseOptions
+		Want []string
+	}{
+		{
+			Name: "Default",
+			Want: []string{
+				"2009-11-10T23:59:59Z",
+				"2009-11-10T23:59:59Z",
+				"2009-11-11T00:00:00Z",
+			},
+		},
+		{
+			Name: "Milliseconds",
+			Opts: ProseOptions{TimePrecision: time.Millisecond},
+			Want: []string{
+				"2009-11-10T23:59:59.250Z",
+				"2009-11-10T23:59:59.750Z",
+				"2009-11-11T00:00:00.750Z",
+			},
+		},
+		{
+			Name: "TimeOnly",
+			Opts: ProseOptions{TimePrecision: time.Microsecond, TimeOnly: true},
+			Want: []string{
+				"2009-11-10T23:59:59.250000Z",
+				"23:59:59.750000",
+				"2009-11-11T00:00:00.750000Z",
+			},
+		},
+		{
+			Name: "Delta",
+			Opts: ProseOptions{Layout: "{delta:8}|{msg}"},
+			Want: []string{
+				"msg",
+				"+500ms  |msg",
+				"+1s     |msg",
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := tc.Opts
+			if opts.Layout == "" {
+				opts.Layout = "{time}"
+			}
+			h := proseHandler(&buf, &Options{OmitSource: true, Prose: &opts})
+			for _, ts := range times {
+				h.Handle(ctx, slog.NewRecord(ts, slog.LevelInfo, "msg", 0))
+			}
+			got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			for i := range got {
+				got[i] = strings.TrimSuffix(got[i], "\x1d\x1e")
+			}
+			if !cmp.Equal(got, tc.Want) {
+				t.Error(cmp.Diff(got, tc.Want))
+			}
+		})
+	}
+
+	t.Run("Local", func(t *testing.T) {
+		var buf bytes.Buffer
+		h := proseHandler(&buf, &Options{
+			OmitSource: true,
+			Prose:      &ProseOptions{Layout: "{time}", LocalTime: true},
+		})
+		h.Handle(ctx, slog.NewRecord(base, slog.LevelInfo, "msg", 0))
+		got := strings.TrimSuffix(buf.String(), "\x1d\x1e\n")
+		if want := base.Local().Format(time.RFC3339); got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	})
+}
+
+type rawJSON string
+
+func (r rawJSON) MarshalJSON() ([]byte, error) { return []byte(r), nil }
+
+func TestProseExpanded(t *testing.T) {
+	var buf bytes.Buffer
+	h := proseHandler(&buf, &Options{
+		OmitSource: true,
+		OmitTime:   true,
+		Prose: &ProseOptions{
+			Layout:      "{msg} {attrs}",
+			Expanded:    true,
+			ExpandWidth: 20,
+			PrettyJSON:  true,
+		},
+	})
+	log := slog.New(h)
+	log.Info("first line\nsecond line",
+		"short", "value",
+		"stack", "goroutine 1:\nmain.main()",
+		"long", strings.Repeat("x", 21),
+		"json", rawJSON(`{"a":[1,2]}`),
+	)
+	log.Info("next")
+	want := "first line\n" +
+		"    second line\x1d short=\"value\"\x1f stack=\n" +
+		"    goroutine 1:\n" +
+		"    main.main()\n" +
+		"\x1f long=\n" +
+		"    xxxxxxxxxxxxxxxxxxxxx\n" +
+		"\x1f json=\n" +
+		"    {\n" +
+		"      \"a\": [\n" +
+		"        1,\n" +
+		"        2\n" +
+		"      ]\n" +
+		"    }\n" +
+		"\x1f\x1e\n" +
+		"next\x1d\x1e\n"
+	if got := buf.String(); got != want {
+		t.Error(cmp.Diff(got, want))
+	}
+	// Records are still delimited by the record separator.
+	if got := strings.Count(buf.String(), "\x1e\n"); got != 2 {
+		t.Errorf("got: %d records, want: 2", got)
+	}
+}
+
+type countingError struct{ n *int }
+
+func (e countingError) Error() string { *e.n++; return "counted" }
+
+func TestProseFormatOnce(t *testing.T) {
+	for _, expanded := range []bool{false, true} {
+		var n int
+		h := proseHandler(io.Discard, &Options{
+			Prose: &ProseOptions{Expanded: expanded},
+		})
+		slog.New(h).Info("msg", "err", countingError{&n})
+		if n != 1 {
+			t.Errorf("expanded: %v: Error called %d times, want 1", expanded, n)
+		}
+	}
+}
+
+func TestProseSource(t *testing.T) {
+	tt := []struct {
+		Name string
+		Opts ProseOptions
+		Link func(file string, line int) string
+	}{
+		{
+			Name: "Default",
+			Link: func(file string, _ int) string {
+				return (&url.URL{Scheme: "file", Host: hostname(), Path: file}).String()
+			},
+		},
+		{
+			Name: "Editor",
+			Opts: ProseOptions{SourceURL: "vscode://file{path}:{line}"},
+			Link: func(file string, line int) string {
+				return fmt.Sprintf("vscode://file%s:%d", file, line)
+			},
+		},
+		{
+			Name: "Repository",
+			Opts: ProseOptions{
+				SourceURL:  "https://example.com/blob/{revision}/{relpath}#L{line}",
+				SourceRoot: "..",
+			},
+			Link: func(_ string, line int) string {
+				return fmt.Sprintf("https://example.com/blob/%s/v2/prose_test.go#L%d", buildInfo().Revision, line)
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			if tc.Opts.SourceRoot != "" {
+				root, err := filepath.Abs(tc.Opts.SourceRoot)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tc.Opts.SourceRoot = root
+			}
+			var buf bytes.Buffer
+			opts := tc.Opts
+			opts.Layout = "{source}"
+			opts.SourceLocation = true
+			h := proseHandler(&buf, &Options{OmitTime: true, Prose: &opts, forceANSI: true})
+			_, file, line, _ := runtime.Caller(0)
+			slog.New(h).Info("msg")
+			line++
+
+			got := buf.String()
+			want := "\x1b]8;;" + tc.Link(file, line) + "\x1b\\"
+			if !strings.HasPrefix(got, want) {
+				t.Errorf("got: %q, want prefix: %q", got, want)
+			}
+			if want := fmt.Sprintf("v2/prose_test.go:%d", line); !strings.Contains(got, want) {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProseWrap(t *testing.T) {
+	newLog := func(buf *bytes.Buffer, po ProseOptions) *slog.Logger {
+		po.Layout = "{level:5} {msg} {attrs}"
+		return slog.New(proseHandler(buf, &Options{
+			OmitSource: true,
+			OmitTime:   true,
+			Prose:      &po,
+			forceWidth: 50,
+		}))
+	}
+	t.Run("Aligned", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := newLog(&buf, ProseOptions{})
+		log.Info("longer message", "a", 1)
+		log.Info("short", "b", 2)
+		log.Info("short", "key", "value", "other", "value", "third", "value")
+		want := "INFO \x1f longer message\x1d a=1\x1f\x1e\n" +
+			"INFO \x1f short\x1d          b=2\x1f\x1e\n" +
+			"INFO \x1f short\x1d          key=\"value\"\x1f other=\"value\"\x1f\n" +
+			"                     third=\"value\"\x1f\x1e\n"
+		if got := buf.String(); got != want {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+	t.Run("Compact", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := newLog(&buf, ProseOptions{Compact: true})
+		log.Info("longer message", "a", 1)
+		log.Info("short", "key", "value", "other", "value", "third", "value")
+		want := "INFO \x1f longer message\x1d a=1\x1f\x1e\n" +
+			"INFO \x1f short\x1d key=\"value\"\x1f other=\"value\"\x1f third=\"value\"\x1f\x1e\n"
+		if got := buf.String(); got != want {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+}
+
+func TestProseColors(t *testing.T) {
+	def := strings.Split(DefaultProseColors, ":")
+	tt := []struct {
+		Name  string
+		Theme string
+		Spec  string
+		Set   bool
+		Want  map[int]string
+	}{
+		{
+			Name: "Default",
+			Want: map[int]string{printErrorLevel: def[printErrorLevel], printKey: def[printKey]},
+		},
+		{
+			Name: "Positional",
+			Spec: "::::::::::::::5x",
+			Set:  true,
+			Want: map[int]string{printErrorLevel: "", printErrorVal: "5", printKey: ""},
+		},
+		{
+			Name: "Keyed",
+			Spec: "level.error=1;31:key=36:url=4:msg=#fdf6e3:bogus=1",
+			Set:  true,
+			Want: map[int]string{
+				printErrorLevel: "1;31",
+				printKey:        "36",
+				printURL:        "4",
+				printMessage:    "38;2;253;246;227",
+				printWarnLevel:  def[printWarnLevel],
+			},
+		},
+		{
+			Name: "Invalid",
+			Spec: "key=1;x;#12;38;5;208:source=",
+			Set:  true,
+			Want: map[int]string{printKey: "1;38;5;208", printSource: ""},
+		},
+		{
+			Name:  "Theme",
+			Theme: "solarized",
+			Spec:  "key=36",
+			Set:   true,
+			Want: map[int]string{
+				printErrorLevel: "38;2;220;50;47",
+				printKey:        "36",
+				printMessage:    "",
+			},
+		},
+		{
+			Name:  "UnknownTheme",
+			Theme: "bogus",
+			Want:  map[int]string{printErrorLevel: def[printErrorLevel]},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			p := parseColors(tc.Theme, tc.Spec, tc.Set)
+			for i, want := range tc.Want {
+				if got := p.sgr[i]; got != want {
+					t.Errorf("%s: got: %q, want: %q", colorNames[i], got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProseCorrelation(t *testing.T) {
+	m, err := baggage.NewMember("tenant", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bg, err := baggage.New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := baggage.ContextWithBaggage(context.Background(), bg)
+
+	var buf bytes.Buffer
+	h := proseHandler(&buf, &Options{
+		OmitSource: true,
+		OmitTime:   true,
+		Baggage:    func(string) bool { return true },
+		Prose: &ProseOptions{
+			Layout:          "{attrs}",
+			CorrelationKeys: []string{"request_id", "baggage.tenant", "http.id"},
+		},
+		forceANSI: true,
+	})
+	log := slog.New(h)
+	for _, id := range []string{"one", "two", "one"} {
+		log.InfoContext(ctx, "msg", "request_id", id, "other", id, slog.Group("http", "id", id))
+	}
+	// Every correlated value should be colored the same, wherever it appears,
+	// and other values should not be affected.
+	re := regexp.MustCompile(`=\x1b\[38;5;(\d+)m([^\x1b]*)\x1b\[m`)
+	colors := make(map[string]string)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for i, line := range lines {
+		ms := re.FindAllStringSubmatch(line, -1)
+		if got, want := len(ms), 3; got != want {
+			t.Fatalf("%d: got: %d correlated values, want: %d: %q", i, got, want, line)
+		}
+		for _, m := range ms {
+			c, v := m[1], m[2]
+			if prev, ok := colors[v]; ok && prev != c {
+				t.Errorf("%d: value %s: got: color %s, want: %s", i, v, c, prev)
+			}
+			colors[v] = c
+		}
+	}
+	if len(colors) != 3 {
+		t.Errorf("got: %v, want 3 values", colors)
+	}
+
+	// Correlation keys added with WithAttrs are colored the same way, even as
+	// the last Attr.
+	buf.Reset()
+	log.With("request_id", "one").Info("msg")
+	ms := re.FindAllStringSubmatch(buf.String(), -1)
+	if len(ms) != 1 || ms[0][2] != `"one"` || ms[0][1] != colors[`"one"`] {
+		t.Errorf("got: %q, want color %s for %q", buf.String(), colors[`"one"`], "one")
+	}
+}
+
+func TestTermCaps(t *testing.T) {
+	tt := []struct {
+		Name string
+		Env  map[string]string
+		TTY  bool
+		Want termCaps
+	}{
+		{Name: "NotTTY", Env: map[string]string{"TERM": "xterm-256color"}},
+		{Name: "Dumb", Env: map[string]string{"TERM": "dumb"}, TTY: true},
+		{
+			Name: "NoColor",
+			Env:  map[string]string{"TERM": "xterm", "NO_COLOR": "1"},
+			TTY:  true,
+		},
+		{
+			Name: "Basic",
+			Env:  map[string]string{"TERM": "xterm"},
+			TTY:  true,
+			Want: termCaps{depth: color16, links: true},
+		},
+		{
+			Name: "Console",
+			Env:  map[string]string{"TERM": "linux"},
+			TTY:  true,
+			Want: termCaps{depth: color16},
+		},
+		{
+			Name: "256",
+			Env:  map[string]string{"TERM": "xterm-256color"},
+			TTY:  true,
+			Want: termCaps{depth: color256, links: true},
+		},
+		{
+			Name: "Truecolor",
+			Env:  map[string]string{"TERM": "xterm-256color", "COLORTERM": "truecolor"},
+			TTY:  true,
+			Want: termCaps{depth: colorTrue, links: true},
+		},
+		{
+			Name: "Screen",
+			Env:  map[string]string{"TERM": "screen-256color"},
+			TTY:  true,
+			Want: termCaps{depth: color256},
+		},
+		{
+			Name: "OldTmux",
+			Env:  map[string]string{"TERM": "tmux-256color", "TERM_PROGRAM": "tmux", "TERM_PROGRAM_VERSION": "3.2a"},
+			TTY:  true,
+			Want: termCaps{depth: color256},
+		},
+		{
+			Name: "Tmux",
+			Env:  map[string]string{"TERM": "tmux-256color", "TERM_PROGRAM": "tmux", "TERM_PROGRAM_VERSION": "3.4"},
+			TTY:  true,
+			Want: termCaps{depth: color256, links: true},
+		},
+		{
+			Name: "CLICOLOR_FORCE",
+			Env:  map[string]string{"TERM": "xterm", "CLICOLOR_FORCE": "1"},
+			Want: termCaps{depth: color16, links: true},
+		},
+		{
+			Name: "FORCE_COLOR",
+			Env:  map[string]string{"FORCE_COLOR": "3", "NO_COLOR": "1", "FORCE_HYPERLINK": "0"},
+			Want: termCaps{depth: colorTrue},
+		},
+		{
+			Name: "FORCE_COLOR=0",
+			Env:  map[string]string{"TERM": "xterm", "FORCE_COLOR": "0"},
+			TTY:  true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			getenv := func(k string) string { return tc.Env[k] }
+			if got := detectTerm(getenv, tc.TTY); got != tc.Want {
+				t.Errorf("got: %+v, want: %+v", got, tc.Want)
+			}
+		})
+	}
+}
+
+func TestDowngradeSGR(t *testing.T) {
+	tt := []struct {
+		In    string
+		Depth colorDepth
+		Want  string
+	}{
+		{"1;31", color16, "1;31"},
+		{"38;2;220;50;47", colorTrue, "38;2;220;50;47"},
+		{"38;2;220;50;47", color256, "38;5;166"},
+		{"38;2;220;50;47", color16, "91"},
+		{"1;38;5;208", color16, "1;91"},
+		{"48;5;18", color16, "44"},
+		{"38;5;250", color16, "37"},
+		{"38;5", color16, ""},
+	}
+	for _, tc := range tt {
+		if got := downgradeSGR(tc.In, tc.Depth); got != tc.Want {
+			t.Errorf("%q at %d: got: %q, want: %q", tc.In, tc.Depth, got, tc.Want)
+		}
+	}
+}
+
+type textValue string
+
+func (t textValue) MarshalText() ([]byte, error) { return []byte(t), nil }
+
+func TestProseSanitize(t *testing.T) {
+	for _, ansi := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ANSI=%v", ansi), func(t *testing.T) {
+			var buf bytes.Buffer
+			h := proseHandler(&buf, &Options{
+				OmitSource: true,
+				OmitTime:   true,
+				Prose:      &ProseOptions{Layout: "{msg} {attrs}"},
+				forceANSI:  ansi,
+			})
+			slog.New(h).WithGroup("g\x1b[1m").Info("clear\x1b[2J\rscreen",
+				"err", errors.New("bad\x1e\x1f"),
+				"text", textValue("a\u009bb\x9b\x7f"),
+				"k\x1b]8;;x\x07", "v",
+				"json", rawJSON("[\"\x1b\"]"),
+				"any", []string{"\x1b"},
+				"str", "\x1b",
+			)
+			got := appendStripped(nil, buf.Bytes())
+			want := "clear␛[2J␍screen\x1d " +
+				"g␛[1m.err=bad␞␟\x1f " +
+				"g␛[1m.text=a\\u009bb\uFFFD␡\x1f " +
+				"g␛[1m.k␛]8;;x␇=\"v\"\x1f " +
+				"g␛[1m.json=[\"␛\"]\x1f " +
+				"g␛[1m.any=[␛]\x1f " +
+				"g␛[1m.str=\"\\x1b\"\x1f\x1e\n"
+			if got := string(got); got != want {
+				t.Error(cmp.Diff(got, want))
+			}
+			// Only zlog's own escapes should be present.
+			if n := bytes.Count(buf.Bytes(), []byte{0x1b}); !ansi && n != 0 {
+				t.Errorf("got: %d escapes", n)
+			}
+			if n := bytes.Count(buf.Bytes(), []byte{0x1e}); n != 1 {
+				t.Errorf("got: %d record separators", n)
+			}
+
+			// The opaque part of a URL isn't escaped by its String method.
+			buf.Reset()
+			u := &url.URL{Scheme: "x", Opaque: "a\x1b]8;;evil\x1b\\\nb\x07"}
+			slog.New(h).Info("msg", "url", u)
+			got = appendStripped(nil, buf.Bytes())
+			want = "msg\x1d url=x:a␛]8;;evil␛\\\nb␇\x1f\x1e\n"
+			if got := string(got); got != want {
+				t.Error(cmp.Diff(got, want))
+			}
+			// Two escapes each to start and end the link, and two for the
+			// key's color.
+			if n := bytes.Count(buf.Bytes(), []byte{0x1b}); ansi && n != 6 {
+				t.Errorf("got: %d escapes, want: 6", n)
+			}
+		})
+	}
+}
+
+func TestProseDecoder(t *testing.T) {
+	ts := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	tt := []struct {
+		Name string
+		Opts Options
+	}{
+		{Name: "Default"},
+		{Name: "ANSI", Opts: Options{forceANSI: true}},
+		{
+			Name: "Wrapped",
+			Opts: Options{forceANSI: true, forceWidth: 40},
+		},
+		{
+			Name: "Layout",
+			Opts: Options{Prose: &ProseOptions{
+				Layout:         "{time} [{level}]: {delta} {source} -- {msg} {attrs}",
+				TimePrecision:  time.Millisecond,
+				TimeOnly:       true,
+				SourceLocation: true,
+			}},
+		},
+		{
+			Name: "Expanded",
+			Opts: Options{Prose: &ProseOptions{Expanded: true, ExpandWidth: 10}},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := tc.Opts
+			opts.Level = LevelEverything
+			h := proseHandler(&buf, &opts)
+			log := slog.New(h).With("component", "test")
+			for i, l := range []slog.Level{slog.LevelInfo, slog.LevelDebug - 2, slog.LevelError} {
+				r := slog.NewRecord(ts.Add(time.Duration(i)*time.Second), l, "message\nnumber "+strconv.Itoa(i), 0)
+				r.AddAttrs(
+					slog.Int("i", i),
+					slog.Bool("ok", true),
+					slog.Float64("f", 1.5),
+					slog.Any("err", errors.New("long enough error text")),
+					slog.Group("req", slog.String("method", "GET"), slog.Group("url", slog.String("path", "/a b"))),
+				)
+				if err := log.Handler().Handle(context.Background(), r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			log.Info("last")
+			t.Logf("%q", buf.String())
+			// Simulate a terminal's newline translation.
+			in := bytes.ReplaceAll(buf.Bytes(), []byte("\n"), []byte("\r\n"))
+
+			dec := NewProseDecoder(bytes.NewReader(in))
+			for i, l := range []slog.Level{slog.LevelInfo, slog.LevelDebug - 2, slog.LevelError} {
+				rec, err := dec.Decode()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := rec.Level, l; got != want {
+					t.Errorf("%d: got level: %v, want: %v", i, got, want)
+				}
+				if got, want := rec.Message, "message\nnumber "+strconv.Itoa(i); got != want {
+					t.Errorf("%d: got message: %q, want: %q", i, got, want)
+				}
+				if got, want := rec.Time, ts.Add(time.Duration(i)*time.Second); !got.Equal(want) {
+					t.Errorf("%d: got time: %v, want: %v", i, got, want)
+				}
+				got := slog.GroupValue(rec.Attrs...).String()
+				want := slog.GroupValue(
+					slog.String("component", "test"),
+					slog.Int64("i", int64(i)),
+					slog.Bool("ok", true),
+					slog.Float64("f", 1.5),
+					slog.String("err", "long enough error text"),
+					slog.Group("req", slog.String("method", "GET"), slog.Group("url", slog.String("path", "/a b"))),
+				).String()
+				if got != want {
+					t.Errorf("%d: got attrs: %s, want: %s", i, got, want)
+				}
+			}
+			rec, err := dec.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Message != "last" || rec.Source == "" {
+				t.Errorf("got: %+v", rec)
+			}
+			if _, err := dec.Decode(); !errors.Is(err, io.EOF) {
+				t.Errorf("got: %v, want: %v", err, io.EOF)
+			}
+		})
+	}
+
+	t.Run("TimeMessage", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := slog.New(proseHandler(&buf, &Options{}))
+		log.Error(ts.Format(time.RFC3339))
+		log.Error("")
+		dec := NewProseDecoder(&buf)
+		for _, want := range []string{ts.Format(time.RFC3339), ""} {
+			rec, err := dec.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.Message; got != want {
+				t.Errorf("got message: %q, want: %q", got, want)
+			}
+			if rec.Time.IsZero() {
+				t.Error("missing time")
+			}
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		dec := NewProseDecoder(strings.NewReader("INFO\x1f msg\x1e\nINFO\x1f msg\x1d a\x1f\x1e\nINFO\x1f ok\x1d\x1e\nINFO"))
+		for _, want := range []error{ErrProseSyntax, ErrProseSyntax, nil, io.ErrUnexpectedEOF} {
+			if _, err := dec.Decode(); !errors.Is(err, want) {
+				t.Errorf("got: %v, want: %v", err, want)
+			}
+		}
+	})
+}
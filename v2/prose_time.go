@@ -0,0 +1,68 @@
+package zlog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ProseClock formats the timestamps for prose output, according to the
+// [ProseOptions].
+//
+// It's shared by all the Handlers derived from a prose Handler, so that the
+// delta from the previous record can be computed.
+type proseClock struct {
+	loc      *time.Location
+	frac     string
+	timeOnly bool
+	// Last is the time of the previous record, in nanoseconds since the Unix
+	// epoch, or 0 if there's been no record.
+	last atomic.Int64
+}
+
+// NewProseClock returns a proseClock configured by "po".
+func newProseClock(po *ProseOptions) *proseClock {
+	c := &proseClock{
+		loc:      time.UTC,
+		timeOnly: po.TimeOnly,
+	}
+	if po.LocalTime {
+		c.loc = time.Local
+	}
+	switch p := po.TimePrecision; {
+	case p <= 0 || p >= time.Second:
+	case p >= time.Millisecond:
+		c.frac = ".000"
+	case p >= time.Microsecond:
+		c.frac = ".000000"
+	default:
+		c.frac = ".000000000"
+	}
+	return c
+}
+
+// Next records "t" as the time of the current record, and returns the layout
+// to format it with and the delta from the previous record. The delta is only
+// valid if "ok" is true.
+func (c *proseClock) Next(t time.Time) (_ time.Time, layout string, delta time.Duration, ok bool) {
+	t = t.In(c.loc)
+	prev := c.last.Swap(t.UnixNano())
+	if prev != 0 {
+		delta, ok = t.Sub(time.Unix(0, prev)), true
+	}
+	layout = "2006-01-02T15:04:05" + c.frac + "Z07:00"
+	if c.timeOnly && ok {
+		py, pm, pd := time.Unix(0, prev).In(c.loc).Date()
+		if y, m, d := t.Date(); y == py && m == pm && d == pd {
+			layout = "15:04:05" + c.frac
+		}
+	}
+	return t, layout, delta, ok
+}
+
+// AppendDelta formats "d" as a signed duration, like "+1.5ms".
+func appendDelta(b *buffer, d time.Duration) {
+	if d >= 0 {
+		b.WriteByte('+')
+	}
+	b.WriteString(d.String())
+}
@@ -0,0 +1,86 @@
+package zlog
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// ProseWrapper aligns the Attrs of prose records into a column and wraps long
+// lines with a hanging indent, according to the width of a terminal.
+//
+// All methods are OK to call on a nil receiver, and will result in no
+// changes.
+type proseWrapper struct {
+	width func() int
+	// Align is the column the Attrs start at. It only grows, up to half of
+	// the terminal width, so that the columns stay put.
+	align atomic.Int64
+}
+
+// NewProseWrapper returns a proseWrapper for "w", or nil if "w" isn't a
+// terminal or compact output is configured.
+func newProseWrapper(w io.Writer, opts *Options, po *ProseOptions) *proseWrapper {
+	if po.Compact {
+		return nil
+	}
+	if n := opts.forceWidth; n != 0 {
+		return &proseWrapper{width: func() int { return n }}
+	}
+	width, ok := termWidth(w)
+	if !ok {
+		return nil
+	}
+	return &proseWrapper{width: width}
+}
+
+// Apply aligns and wraps the record in "b", where the Attrs start at offset
+// "attrsAt".
+func (pw *proseWrapper) Apply(b *buffer, attrsAt int) {
+	if pw == nil {
+		return
+	}
+	width := pw.width()
+	if width <= 0 {
+		return
+	}
+	head := visibleWidth((*b)[:attrsAt])
+	if nl := bytes.LastIndexByte((*b)[:attrsAt], '\n'); nl != -1 {
+		head = visibleWidth((*b)[nl+1 : attrsAt])
+	}
+	for {
+		cur := pw.align.Load()
+		if int64(head) <= cur || head > width/2 || pw.align.CompareAndSwap(cur, int64(head)) {
+			break
+		}
+	}
+	attrs := bytes.Clone((*b)[attrsAt:])
+	*b = (*b)[:attrsAt]
+	col := head
+	if a := int(pw.align.Load()); a > col {
+		b.WriteString(strings.Repeat(" ", a-col))
+		col = a
+	}
+	indent := col
+	if indent > width/2 {
+		indent = len(proseIndent)
+	}
+	for _, seg := range bytes.SplitAfter(attrs, []byte{0x1f}) {
+		if len(seg) == 0 {
+			continue
+		}
+		if w := visibleWidth(seg); col+w > width && col > indent {
+			b.WriteByte('\n')
+			b.WriteString(strings.Repeat(" ", indent))
+			col = indent
+			seg = bytes.TrimPrefix(seg, []byte(" "))
+		}
+		b.Write(seg)
+		if nl := bytes.LastIndexByte(seg, '\n'); nl != -1 {
+			col = visibleWidth(seg[nl+1:])
+		} else {
+			col += visibleWidth(seg)
+		}
+	}
+}
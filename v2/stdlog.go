@@ -0,0 +1,148 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"log/slog"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// LogOptions is used to configure the [log.Logger] returned by [NewLogLogger]
+// and the redirection done by [RedirectStdLog].
+type LogOptions struct {
+	// Level is the level of the records. If unset, [slog.LevelInfo] is used.
+	Level slog.Leveler
+	// ParseLevel causes common level prefixes to be recognized and removed
+	// from the message: for example, "[ERROR]", "WARN:", and "level=debug".
+	// Lines without a recognized prefix are logged at Level.
+	ParseLevel bool
+}
+
+// NewLogLogger returns a [log.Logger] that emits every line written to it as a
+// record to "h".
+//
+// If the Logger's flags are changed to include the date or time, the
+// timestamp is removed from the message, as the record has its own. Source
+// information reports the caller of the [log.Logger] method.
+//
+// If "nil" is passed for options, suitable defaults will be used.
+func NewLogLogger(h slog.Handler, opts *LogOptions) *log.Logger {
+	w := newLogWriter(h, opts)
+	l := log.New(w, "", 0)
+	w.flags = l.Flags
+	return l
+}
+
+// RedirectStdLog configures the [log] package's default Logger to emit records
+// to "h", in the same manner as [NewLogLogger].
+//
+// The returned function restores the previous configuration.
+//
+// The Handler "h" must not write to the log package's default Logger; for
+// example, the Handler used by the default [slog.Logger] if [slog.SetDefault]
+// has not been called.
+func RedirectStdLog(h slog.Handler, opts *LogOptions) (restore func()) {
+	w, flags, prefix := log.Writer(), log.Flags(), log.Prefix()
+	lw := newLogWriter(h, opts)
+	lw.flags = log.Flags
+	log.SetOutput(lw)
+	log.SetFlags(0)
+	log.SetPrefix("")
+	return func() {
+		log.SetOutput(w)
+		log.SetFlags(flags)
+		log.SetPrefix(prefix)
+	}
+}
+
+// LogWriter is the [io.Writer] used to bridge the log package.
+type logWriter struct {
+	h     slog.Handler
+	level slog.Leveler
+	parse bool
+	// Flags reports the flags of the Logger writing to the logWriter.
+	flags func() int
+}
+
+func newLogWriter(h slog.Handler, opts *LogOptions) *logWriter {
+	if opts == nil {
+		opts = &LogOptions{}
+	}
+	w := &logWriter{h: h, level: opts.Level, parse: opts.ParseLevel}
+	if w.level == nil {
+		w.level = slog.LevelInfo
+	}
+	return w
+}
+
+// Write implements [io.Writer].
+func (w *logWriter) Write(b []byte) (int, error) {
+	n := len(b) // Report that the entire buffer was written.
+	ctx := context.Background()
+	msg := string(bytes.TrimSuffix(b, []byte{'\n'}))
+	if w.flags()&(log.Ldate|log.Ltime|log.Lmicroseconds) != 0 {
+		msg = logTimestamp.ReplaceAllLiteralString(msg, "")
+	}
+	l := w.level.Level()
+	if w.parse {
+		l, msg = parseLogLevel(msg, l)
+	}
+	if !w.h.Enabled(ctx, l) {
+		return n, nil
+	}
+	// Skip [runtime.Callers, this function, log.Logger.output, and the
+	// log.Logger method or package function].
+	var pcs [1]uintptr
+	runtime.Callers(4, pcs[:])
+	r := slog.NewRecord(time.Now(), l, msg, pcs[0])
+	return n, w.h.Handle(ctx, r)
+}
+
+// LogTimestamp matches a leading timestamp in the formats the log package
+// produces.
+var logTimestamp = regexp.MustCompile(`^(?:` +
+	`(?:\d{4}/\d{2}/\d{2} )?\d{2}:\d{2}:\d{2}(?:\.\d{6})? ` + // log.LstdFlags, log.Lmicroseconds
+	`|\d{4}/\d{2}/\d{2} ` + // log.Ldate
+	`)`)
+
+// LogLevelPrefix matches a leading level indicator.
+var logLevelPrefix = regexp.MustCompile(`^(?:\[([A-Za-z]+)\]:?|([A-Za-z]+):|level=([A-Za-z]+))(?:\s+|$)`)
+
+// LogLevels maps the upper-cased words recognized as levels.
+var logLevels = map[string]slog.Level{
+	"TRACE":    slog.LevelDebug - 4,
+	"DEBUG":    slog.LevelDebug,
+	"DBG":      slog.LevelDebug,
+	"INFO":     slog.LevelInfo,
+	"INF":      slog.LevelInfo,
+	"NOTICE":   SyslogNotice,
+	"WARN":     slog.LevelWarn,
+	"WARNING":  slog.LevelWarn,
+	"WRN":      slog.LevelWarn,
+	"ERROR":    slog.LevelError,
+	"ERR":      slog.LevelError,
+	"CRIT":     SyslogCritical,
+	"CRITICAL": SyslogCritical,
+	"FATAL":    SyslogCritical,
+	"PANIC":    SyslogEmergency,
+}
+
+// ParseLogLevel removes a recognized level prefix from "msg", reporting the
+// level and the remaining message. If no prefix is recognized, "def" and the
+// unmodified message are returned.
+func parseLogLevel(msg string, def slog.Level) (slog.Level, string) {
+	m := logLevelPrefix.FindStringSubmatch(msg)
+	if m == nil {
+		return def, msg
+	}
+	word := m[1] + m[2] + m[3] // Only one group matches.
+	l, ok := logLevels[strings.ToUpper(word)]
+	if !ok {
+		return def, msg
+	}
+	return l, msg[len(m[0]):]
+}
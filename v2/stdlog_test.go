@@ -0,0 +1,73 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"testing"
+)
+
+func TestStdLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandler(&buf, &Options{
+		Level:    LevelEverything,
+		OmitTime: true,
+	})
+	parsing := NewLogLogger(h, &LogOptions{ParseLevel: true})
+	timed := NewLogLogger(h, &LogOptions{ParseLevel: true})
+	timed.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	plain := NewLogLogger(h, nil)
+	tt := []struct {
+		Logger *log.Logger
+		In     string
+		Level  slog.Level
+		Msg    string
+	}{
+		{parsing, "plain message", slog.LevelInfo, "plain message"},
+		{parsing, "[ERROR] bad thing", slog.LevelError, "bad thing"},
+		{parsing, "[warn]: odd thing", slog.LevelWarn, "odd thing"},
+		{parsing, "WARN: odd thing", slog.LevelWarn, "odd thing"},
+		{parsing, "level=debug detail", slog.LevelDebug, "detail"},
+		{parsing, "note: not a level", slog.LevelInfo, "note: not a level"},
+		// Timestamps are only removed if the Logger adds them.
+		{parsing, "23:00:00 detail", slog.LevelInfo, "23:00:00 detail"},
+		{timed, "[DEBUG] detail", slog.LevelDebug, "detail"},
+		{timed, "23:00:00 detail", slog.LevelInfo, "23:00:00 detail"},
+		// Levels are only parsed if asked.
+		{plain, "[ERROR] bad thing", slog.LevelInfo, "[ERROR] bad thing"},
+	}
+	for _, tc := range tt {
+		buf.Reset()
+		tc.Logger.Print(tc.In)
+		var got struct {
+			Level  slog.Level
+			Msg    string
+			Source string
+		}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("%q: %v", buf.String(), err)
+		}
+		if got.Level != tc.Level {
+			t.Errorf("%q: got: %v, want: %v", tc.In, got.Level, tc.Level)
+		}
+		if got.Msg != tc.Msg {
+			t.Errorf("%q: got: %q, want: %q", tc.In, got.Msg, tc.Msg)
+		}
+		if got, want := got.Source, "github.com/quay/zlog/v2.TestStdLog"; got != want {
+			t.Errorf("%q: got: %q, want: %q", tc.In, got, want)
+		}
+	}
+}
+
+func TestRedirectStdLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandler(&buf, &Options{OmitTime: true})
+	restore := RedirectStdLog(h, &LogOptions{ParseLevel: true})
+	log.Printf("[WARN] %d", 5)
+	restore()
+	want := `{"level":"WARN","source":"github.com/quay/zlog/v2.TestRedirectStdLog","msg":"5"}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got: %#q, want: %#q", got, want)
+	}
+}
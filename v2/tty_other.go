@@ -5,3 +5,5 @@ package zlog
 import "io"
 
 func isatty(_ io.Writer) bool { return false }
+
+func termWidth(_ io.Writer) (func() int, bool) { return nil, false }
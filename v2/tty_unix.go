@@ -5,6 +5,9 @@ package zlog
 import (
 	"io"
 	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
 
 	"golang.org/x/sys/unix"
 )
@@ -18,3 +21,59 @@ func isatty(w io.Writer) bool {
 	_, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
 	return err == nil
 }
+
+// TermWidth returns a function reporting the current width of the terminal
+// "w" refers to, or false if "w" isn't a terminal.
+//
+// The width is updated when the process receives SIGWINCH. Handlers writing
+// to the same file descriptor share the width.
+func termWidth(w io.Writer) (func() int, bool) {
+	f, ok := w.(*os.File)
+	if !ok {
+		return nil, false
+	}
+	fd := int(f.Fd())
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return nil, false
+	}
+	width := watchResize(fd)
+	width.Store(int64(ws.Col))
+	return func() int { return int(width.Load()) }, true
+}
+
+// Resize holds the widths to update on SIGWINCH, by file descriptor.
+var resize struct {
+	sync.Mutex
+	once  sync.Once
+	width map[int]*atomic.Int64
+}
+
+// WatchResize returns the width for the terminal "fd", which is updated
+// whenever the process receives SIGWINCH.
+func watchResize(fd int) *atomic.Int64 {
+	resize.once.Do(func() {
+		resize.width = make(map[int]*atomic.Int64)
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, unix.SIGWINCH)
+		go func() {
+			for range ch {
+				resize.Lock()
+				for fd, width := range resize.width {
+					if ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ); err == nil {
+						width.Store(int64(ws.Col))
+					}
+				}
+				resize.Unlock()
+			}
+		}()
+	})
+	resize.Lock()
+	defer resize.Unlock()
+	width, ok := resize.width[fd]
+	if !ok {
+		width = new(atomic.Int64)
+		resize.width[fd] = width
+	}
+	return width
+}
@@ -0,0 +1,360 @@
+// Package zloghttp provides [net/http] middleware for request-scoped logging
+// with the handlers from [github.com/quay/zlog/v2].
+//
+// The middleware extracts [W3C Trace Context] and [W3C Baggage] from incoming
+// requests, propagates or generates a request ID, populates the Attrs and
+// level stored at the keys configured in [zlog.Options], and emits an access
+// record once the request has been served.
+//
+// [W3C Trace Context]: https://www.w3.org/TR/trace-context/
+// [W3C Baggage]: https://www.w3.org/TR/baggage/
+package zloghttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/quay/zlog/v2"
+)
+
+// Options is used to configure the middleware returned by [NewMiddleware].
+type Options struct {
+	// Log should be the options used to construct the [slog.Handler] passed
+	// to NewMiddleware.
+	//
+	// If [zlog.Options.ContextKey] is set, the request information is added
+	// to the Attrs stored there. If [zlog.Options.LevelKey] is set, a
+	// [*slog.LevelVar] is stored there for every request, allowing the level
+	// to be adjusted for a single request.
+	Log *zlog.Options
+	// Level returns the initial level for a request. If unset or if it
+	// returns nil, the level from Log is used.
+	Level func(*http.Request) slog.Leveler
+	// RequestIDHeader is the header used to propagate request IDs. If a
+	// request does not have one, or it isn't valid, one is generated. The
+	// request ID is also set on the response.
+	//
+	// A valid request ID is at most [MaxRequestIDLen] bytes of ASCII letters,
+	// digits, and "-", "_", ".", or ":".
+	//
+	// If unset, "X-Request-Id" is used.
+	RequestIDHeader string
+	// Route returns a low-cardinality name for the request, such as the
+	// pattern that matched it.
+	//
+	// If unset, the request path is used.
+	Route func(*http.Request) string
+	// Headers is an allowlist of request headers to add to the access
+	// record. Values are passed through Redact.
+	Headers []string
+	// Redact is called for every header value added to the access record,
+	// and returns the value to be logged.
+	//
+	// If unset, [Redact] is used.
+	Redact func(key, value string) string
+}
+
+// Keys used by the middleware.
+const (
+	// Added to the contextual Attrs:
+	MethodKey     = "method"
+	RouteKey      = "route"
+	RemoteAddrKey = "remote_addr"
+	RequestIDKey  = "request_id"
+	// Added to the access record:
+	StatusKey  = "status"
+	BytesKey   = "bytes"
+	LatencyKey = "latency"
+	HeaderKey  = "header"
+)
+
+// MaxRequestIDLen is the maximum length of a request ID accepted from a
+// request.
+const MaxRequestIDLen = 128
+
+// AccessMessage is the message used for access records.
+const AccessMessage = "http request"
+
+// Redacted is the value that [Redact] replaces sensitive values with.
+const Redacted = "REDACTED"
+
+// Redact is the default redaction rule for header values. Credential-bearing
+// headers are replaced with [Redacted], even if they're explicitly allowed.
+func Redact(key, value string) string {
+	switch http.CanonicalHeaderKey(key) {
+	case "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Token":
+		return Redacted
+	}
+	return value
+}
+
+// Propagator extracts W3C Trace Context and W3C Baggage.
+var propagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// NewMiddleware returns middleware that sets up request-scoped logging and
+// emits access records to "h".
+//
+// If "nil" is passed for options, suitable defaults will be used.
+func NewMiddleware(h slog.Handler, opts *Options) func(http.Handler) http.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	m := middleware{
+		h:        h,
+		log:      opts.Log,
+		level:    opts.Level,
+		idHeader: opts.RequestIDHeader,
+		route:    opts.Route,
+		headers:  opts.Headers,
+		redact:   opts.Redact,
+	}
+	if m.log == nil {
+		m.log = &zlog.Options{}
+	}
+	if m.idHeader == "" {
+		m.idHeader = "X-Request-Id"
+	}
+	if m.route == nil {
+		m.route = func(r *http.Request) string { return r.URL.Path }
+	}
+	if m.redact == nil {
+		m.redact = Redact
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.serve(next, w, r)
+		})
+	}
+}
+
+// Middleware is the configured middleware.
+type middleware struct {
+	h        slog.Handler
+	log      *zlog.Options
+	level    func(*http.Request) slog.Leveler
+	idHeader string
+	route    func(*http.Request) string
+	headers  []string
+	redact   func(string, string) string
+}
+
+// Serve does the setup for a request, calls "next", then emits the access
+// record. The access record is emitted even if "next" panics, in which case
+// it's reported as a server error unless a status was already sent.
+func (m *middleware) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+	id := r.Header.Get(m.idHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(m.idHeader, id)
+
+	if k := m.log.ContextKey; k != nil {
+		attrs := []slog.Attr{
+			slog.String(MethodKey, r.Method),
+			slog.String(RouteKey, m.route(r)),
+			slog.String(RemoteAddrKey, r.RemoteAddr),
+			slog.String(RequestIDKey, id),
+		}
+		// The Handler only reports IDs from recording spans, so report the
+		// remote trace if nothing else is going to.
+		if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() && !trace.SpanFromContext(ctx).IsRecording() {
+			attrs = append(attrs, slog.String(`TraceID`, sc.TraceID().String()))
+		}
+		ctx = withAttrs(ctx, k, attrs)
+	}
+	if k := m.log.LevelKey; k != nil {
+		var lv slog.LevelVar
+		var l slog.Leveler
+		if m.level != nil {
+			l = m.level(r)
+		}
+		if l == nil {
+			l = m.log.Level
+		}
+		if l != nil {
+			lv.Set(l.Level())
+		}
+		ctx = context.WithValue(ctx, k, &lv)
+	}
+
+	rw := &responseWriter{ResponseWriter: w}
+	panicked := true
+	defer func() {
+		// Not recovering leaves the panic, and its stack, untouched.
+		if panicked && rw.status == 0 {
+			rw.status = http.StatusInternalServerError
+		}
+		m.access(ctx, r, rw, start)
+	}()
+	next.ServeHTTP(rw, r.WithContext(ctx))
+	panicked = false
+}
+
+// Access emits the access record for the request "r".
+func (m *middleware) access(ctx context.Context, r *http.Request, rw *responseWriter, start time.Time) {
+	lvl := slog.LevelInfo
+	if rw.status >= 500 {
+		lvl = slog.LevelError
+	}
+	if !m.h.Enabled(ctx, lvl) {
+		return
+	}
+	rec := slog.NewRecord(time.Now(), lvl, AccessMessage, 0)
+	rec.AddAttrs(
+		slog.Int(StatusKey, rw.Status()),
+		slog.Int64(BytesKey, rw.n),
+		slog.Duration(LatencyKey, time.Since(start)),
+	)
+	if len(m.headers) != 0 {
+		var hs []slog.Attr
+		for _, k := range m.headers {
+			vs := r.Header.Values(k)
+			if len(vs) == 0 {
+				continue
+			}
+			hs = append(hs, slog.String(k, m.redact(k, strings.Join(vs, ", "))))
+		}
+		if len(hs) != 0 {
+			rec.AddAttrs(slog.Attr{Key: HeaderKey, Value: slog.GroupValue(hs...)})
+		}
+	}
+	m.h.Handle(ctx, rec)
+}
+
+// WithAttrs returns a Context with "attrs" added to the Group stored at "key".
+//
+// Attrs with the same key as an existing Attr replace it.
+func withAttrs(ctx context.Context, key any, attrs []slog.Attr) context.Context {
+	var s []slog.Attr
+	if v, ok := ctx.Value(key).(slog.Value); ok {
+		for _, a := range v.Group() {
+			replaced := false
+			for _, n := range attrs {
+				if n.Key == a.Key {
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				s = append(s, a)
+			}
+		}
+	}
+	s = append(s, attrs...)
+	return context.WithValue(ctx, key, slog.GroupValue(s...))
+}
+
+// ValidRequestID reports whether "id" is usable as a request ID. This keeps
+// arbitrary client input out of the logs and the response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLen {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewRequestID generates a random request ID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ResponseWriter records the status code and number of bytes written.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+// WriteHeader implements [http.ResponseWriter].
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements [http.ResponseWriter].
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// Flush implements [http.Flusher].
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack implements [http.Hijacker].
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// ReadFrom implements [io.ReaderFrom], so that the wrapped ResponseWriter
+// can still use an optimized copy.
+func (w *responseWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, r)
+	}
+	w.n += n
+	return n, err
+}
+
+// Unwrap allows an [http.ResponseController] to reach the wrapped
+// ResponseWriter.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status reports the status code sent, defaulting to 200 if nothing was
+// written.
+func (w *responseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
@@ -0,0 +1,193 @@
+package zloghttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/quay/zlog/v2"
+)
+
+type ctxkey int
+
+const (
+	_ ctxkey = iota
+	attrsKey
+	levelKey
+)
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &zlog.Options{
+		OmitTime:   true,
+		OmitSource: true,
+		Baggage:    func(string) bool { return true },
+		ContextKey: attrsKey,
+		LevelKey:   levelKey,
+	}
+	h := zlog.NewHandler(&buf, opts)
+	mw := NewMiddleware(h, &Options{
+		Log:     opts,
+		Headers: []string{"User-Agent", "Authorization"},
+		Route:   func(*http.Request) string { return "/item/{id}" },
+	})
+	srv := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := slog.New(h)
+		log.DebugContext(ctx, "hidden")
+		ctx.Value(levelKey).(*slog.LevelVar).Set(slog.LevelDebug)
+		log.DebugContext(ctx, "shown")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/item/1", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("User-Agent", "test")
+	req.Header.Set("Authorization", "Bearer hunter2")
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Baggage", "tenant=example")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if got, want := rec.Header().Get("X-Request-Id"), "abc"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	var got []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		m := make(map[string]any)
+		if err := dec.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		delete(m, LatencyKey)
+		got = append(got, m)
+	}
+	ctxAttrs := func(m map[string]any) map[string]any {
+		m["baggage"] = map[string]any{"tenant": "example"}
+		m[MethodKey] = "GET"
+		m[RouteKey] = "/item/{id}"
+		m[RemoteAddrKey] = "192.0.2.1:1234"
+		m[RequestIDKey] = "abc"
+		m["TraceID"] = "4bf92f3577b34da6a3ce929d0e0e4736"
+		return m
+	}
+	want := []map[string]any{
+		ctxAttrs(map[string]any{
+			"level": "DEBUG",
+			"msg":   "shown",
+		}),
+		ctxAttrs(map[string]any{
+			"level":   "INFO",
+			"msg":     AccessMessage,
+			StatusKey: float64(http.StatusTeapot),
+			BytesKey:  float64(15),
+			HeaderKey: map[string]any{
+				"User-Agent":    "test",
+				"Authorization": Redacted,
+			},
+		}),
+	}
+	if !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	srv := NewMiddleware(zlog.NewHandler(&buf, nil), nil)(http.NotFoundHandler())
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("X-Request-Id"); len(got) != 32 {
+		t.Errorf("unexpected request ID: %q", got)
+	}
+	if buf.Len() == 0 {
+		t.Error("no access record")
+	}
+}
+
+func TestRequestIDValidation(t *testing.T) {
+	for _, tc := range []struct {
+		ID   string
+		Keep bool
+	}{
+		{"0af7651916cd43dd8448eb211c80319c", true},
+		{"req-1.2_3:4", true},
+		{strings.Repeat("a", MaxRequestIDLen), true},
+		{strings.Repeat("a", MaxRequestIDLen+1), false},
+		{"bad\nid", false},
+		{"bad id", false},
+		{`bad"id`, false},
+	} {
+		srv := NewMiddleware(zlog.NewHandler(io.Discard, nil), nil)(http.NotFoundHandler())
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-Id", tc.ID)
+		srv.ServeHTTP(rec, req)
+		got := rec.Header().Get("X-Request-Id")
+		if kept := got == tc.ID; kept != tc.Keep {
+			t.Errorf("%q: got: %q", tc.ID, got)
+		}
+		if !tc.Keep && len(got) != 32 {
+			t.Errorf("%q: unexpected request ID: %q", tc.ID, got)
+		}
+	}
+}
+
+// TestInterfaces checks that the wrapped ResponseWriter's optional interfaces
+// are still reachable.
+func TestInterfaces(t *testing.T) {
+	srv := httptest.NewServer(NewMiddleware(zlog.NewHandler(io.Discard, nil), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(io.ReaderFrom); !ok {
+			t.Error("not an io.ReaderFrom")
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n")
+		buf.Flush()
+	})))
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got, want := res.StatusCode, http.StatusNoContent; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	var buf bytes.Buffer
+	srv := NewMiddleware(zlog.NewHandler(&buf, nil), nil)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	func() {
+		defer func() {
+			if r := recover(); r != http.ErrAbortHandler {
+				t.Errorf("unexpected panic value: %v", r)
+			}
+		}()
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	m := make(map[string]any)
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("no access record: %v", err)
+	}
+	if got, want := m[StatusKey], float64(http.StatusInternalServerError); got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
@@ -0,0 +1,292 @@
+package zlogtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/quay/zlog/v2"
+	"github.com/quay/zlog/v2/internal/hooks"
+)
+
+// Recorder is an [slog.Handler] that keeps every record it handles, for making
+// assertions in tests.
+//
+// Records are fully resolved by the handlers from [github.com/quay/zlog/v2]
+// before being recorded, so they include groups, OpenTelemetry baggage, pprof
+// labels, and [zlog.Options.ContextKey] Attrs, exactly as the formatters see
+// them.
+//
+// Handlers derived from a Recorder via WithAttrs or WithGroup share its
+// recording.
+type Recorder struct {
+	rec *recording
+	// Out is the Handler for the formatted output, which runs the pipeline.
+	// Golden is the Handler for the normalized output and dec is the Handler
+	// used for decoding records; both emit each record as it's written by
+	// out.
+	out, golden, dec slog.Handler
+}
+
+var _ slog.Handler = (*Recorder)(nil)
+
+// NewRecorder returns a Recorder that formats records according to "opts".
+//
+// If "nil" is passed for options, every record is recorded.
+func NewRecorder(opts *zlog.Options) *Recorder {
+	if opts == nil {
+		opts = &zlog.Options{Level: zlog.LevelEverything}
+	}
+	rec := &recording{}
+	dec := *opts
+	dec.ProseFormat = false
+	dec.OmitSource = true
+	return &Recorder{
+		rec:    rec,
+		out:    zlog.NewHandler(recordingWriter{rec}, opts),
+		golden: zlog.NewHandler(&rec.golden, opts),
+		dec:    zlog.NewHandler(decodeWriter{}, &dec),
+	}
+}
+
+// Recording is the state shared by a Recorder and the Handlers derived from it.
+type recording struct {
+	sync.Mutex
+	recs        []Record
+	out, golden bytes.Buffer
+}
+
+// RecordingWriter adds the output of a Recorder to its recording, along with
+// the decoded and normalized forms of each record.
+type recordingWriter struct {
+	rec *recording
+}
+
+var _ hooks.RecordWriter = recordingWriter{}
+
+// Write implements [io.Writer]. It's only used for output without a record.
+func (w recordingWriter) Write(b []byte) (int, error) {
+	w.rec.Lock()
+	defer w.rec.Unlock()
+	return w.rec.out.Write(b)
+}
+
+// WriteRecord implements [hooks.RecordWriter].
+func (w recordingWriter) WriteRecord(ctx context.Context, r slog.Record, b []byte) (int, error) {
+	p, ok := ctx.Value(pendingKey{}).(*pending)
+	if !ok {
+		return w.Write(b)
+	}
+	if err := hooks.Emit(p.dec, ctx, r); err != nil {
+		return 0, err
+	}
+	gctx := ctx
+	if !r.Time.IsZero() {
+		r.Time = goldenTime
+	}
+	if r.PC != 0 {
+		r.PC = 0
+		gctx = hooks.WithSource(ctx, goldenSource)
+	}
+
+	w.rec.Lock()
+	defer w.rec.Unlock()
+	w.rec.out.Write(b)
+	w.rec.recs = append(w.rec.recs, p.rec)
+	if err := hooks.Emit(p.golden, gctx, r); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Enabled implements [slog.Handler].
+func (r *Recorder) Enabled(ctx context.Context, l slog.Level) bool {
+	return r.out.Enabled(ctx, l)
+}
+
+// Handle implements [slog.Handler].
+func (r *Recorder) Handle(ctx context.Context, rec slog.Record) error {
+	p := &pending{dec: r.dec, golden: r.golden}
+	return r.out.Handle(context.WithValue(ctx, pendingKey{}, p), rec)
+}
+
+// WithAttrs implements [slog.Handler].
+func (r *Recorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Recorder{
+		rec:    r.rec,
+		out:    r.out.WithAttrs(attrs),
+		golden: r.golden.WithAttrs(attrs),
+		dec:    r.dec.WithAttrs(attrs),
+	}
+}
+
+// WithGroup implements [slog.Handler].
+func (r *Recorder) WithGroup(name string) slog.Handler {
+	return &Recorder{
+		rec:    r.rec,
+		out:    r.out.WithGroup(name),
+		golden: r.golden.WithGroup(name),
+		dec:    r.dec.WithGroup(name),
+	}
+}
+
+// Records returns the records handled so far.
+func (r *Recorder) Records() []Record {
+	r.rec.Lock()
+	defer r.rec.Unlock()
+	return append([]Record(nil), r.rec.recs...)
+}
+
+// Output returns the formatted output so far.
+func (r *Recorder) Output() []byte {
+	r.rec.Lock()
+	defer r.rec.Unlock()
+	return bytes.Clone(r.rec.out.Bytes())
+}
+
+// Golden returns the formatted output so far, with every timestamp and source
+// location replaced by a fixed value, suitable for comparing against a golden
+// file. See [CompareGolden].
+func (r *Recorder) Golden() []byte {
+	r.rec.Lock()
+	defer r.rec.Unlock()
+	return bytes.Clone(r.rec.golden.Bytes())
+}
+
+// Reset discards everything recorded so far.
+func (r *Recorder) Reset() {
+	r.rec.Lock()
+	defer r.rec.Unlock()
+	r.rec.recs = nil
+	r.rec.out.Reset()
+	r.rec.golden.Reset()
+}
+
+// GoldenTime is the timestamp used in normalized output.
+var goldenTime = time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+// GoldenSource is the source used in normalized output. The file is relative,
+// so it's the same wherever the tests are run.
+var goldenSource = &slog.Source{
+	Function: "example.com/golden.Func",
+	File:     "golden/golden.go",
+	Line:     1,
+}
+
+// UpdateGolden is the environment variable that causes [CompareGolden] to
+// write golden files instead of comparing against them.
+const UpdateGolden = "ZLOGTEST_UPDATE_GOLDEN"
+
+// CompareGolden compares "got" to the contents of the file at "path", failing
+// the test "t" if they differ.
+//
+// If the environment variable named by [UpdateGolden] is set to a non-empty
+// value, the file is written with "got" instead.
+func CompareGolden(t testing.TB, path string, got []byte) {
+	t.Helper()
+	if os.Getenv(UpdateGolden) != "" {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (-got, +want):\n%s", path, cmp.Diff(string(got), string(want)))
+	}
+}
+
+// Matcher reports whether a Record meets some condition.
+type Matcher func(Record) bool
+
+// Level returns a Matcher for records at level "l".
+func Level(l slog.Level) Matcher {
+	return func(r Record) bool { return r.Level == l }
+}
+
+// Message returns a Matcher for records with the message "msg".
+func Message(msg string) Matcher {
+	return func(r Record) bool { return r.Message == msg }
+}
+
+// HasAttr returns a Matcher for records with an Attr at "path". See
+// [Record.Value].
+func HasAttr(path string) Matcher {
+	return func(r Record) bool {
+		_, ok := r.Value(path)
+		return ok
+	}
+}
+
+// Attr returns a Matcher for records with an Attr at "path" equal to "v". See
+// [Record.Value].
+//
+// The value "v" is compared after a round-trip through JSON, so that it can be
+// written with its Go type: for example, an int compares equal to the decoded
+// float64.
+func Attr(path string, v any) Matcher {
+	var want any
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(b, &want)
+	}
+	if err != nil {
+		panic("zlogtest: unable to encode attr value: " + err.Error())
+	}
+	return func(r Record) bool {
+		got, ok := r.Value(path)
+		return ok && cmp.Equal(got, want)
+	}
+}
+
+// Filter returns the records in "recs" matching all of "ms".
+func Filter(recs []Record, ms ...Matcher) []Record {
+	var out []Record
+Rec:
+	for _, r := range recs {
+		for _, m := range ms {
+			if !m(r) {
+				continue Rec
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Value returns the value of the Attr at "path", which is a series of keys
+// separated by ".": for example, "baggage.tenant" or "http.request.method".
+//
+// Keys containing a "." are found as long as the path is unambiguous. Groups
+// are returned as a map[string]any.
+func (r Record) Value(path string) (any, bool) {
+	return lookup(r.Attrs, path)
+}
+
+// Lookup resolves "path" in "m", preferring the longest matching key.
+func lookup(m map[string]any, path string) (any, bool) {
+	if v, ok := m[path]; ok {
+		return v, true
+	}
+	for i := strings.LastIndexByte(path, '.'); i != -1; i = strings.LastIndexByte(path[:i], '.') {
+		g, ok := m[path[:i]].(map[string]any)
+		if !ok {
+			continue
+		}
+		if v, ok := lookup(g, path[i+1:]); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,165 @@
+package zlogtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel/baggage"
+
+	"github.com/quay/zlog/v2"
+)
+
+type ctxkey struct{}
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	m, err := baggage.NewMember("tenant", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := baggage.New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = baggage.ContextWithBaggage(ctx, b)
+	ctx = pprof.WithLabels(ctx, pprof.Labels("worker", "1"))
+	ctx = context.WithValue(ctx, ctxkey{}, slog.GroupValue(slog.String("request_id", "abc")))
+
+	rec := NewRecorder(&zlog.Options{
+		Level:      slog.LevelInfo,
+		Baggage:    func(string) bool { return true },
+		ContextKey: ctxkey{},
+	})
+	log := slog.New(rec).With("component", "test").WithGroup("http")
+	log.DebugContext(ctx, "hidden")
+	log.InfoContext(ctx, "request", "status", 200, slog.Group("request", "method", "GET"))
+	log.ErrorContext(ctx, "failed", "error", errors.New("oops"))
+
+	recs := rec.Records()
+	if got, want := len(recs), 2; got != want {
+		t.Fatalf("got: %d records, want: %d", got, want)
+	}
+	for _, tc := range []struct {
+		Matchers []Matcher
+		Want     int
+	}{
+		{[]Matcher{Level(slog.LevelInfo)}, 1},
+		{[]Matcher{Message("failed"), Level(slog.LevelError)}, 1},
+		{[]Matcher{Message("failed"), Level(slog.LevelInfo)}, 0},
+		{[]Matcher{Attr("baggage.tenant", "example")}, 2},
+		{[]Matcher{Attr("goroutine.worker", "1")}, 2},
+		{[]Matcher{Attr("component", "test")}, 2},
+		{[]Matcher{Attr("http.request_id", "abc")}, 2},
+		{[]Matcher{Attr("http.status", 200)}, 1},
+		{[]Matcher{Attr("http.request.method", "GET")}, 1},
+		{[]Matcher{Attr("http.request", map[string]string{"method": "GET"})}, 1},
+		{[]Matcher{HasAttr("http.error")}, 1},
+		{[]Matcher{HasAttr("http.missing")}, 0},
+	} {
+		if got := len(Filter(recs, tc.Matchers...)); got != tc.Want {
+			t.Errorf("%d: got: %d records, want: %d", len(tc.Matchers), got, tc.Want)
+		}
+	}
+	if recs[0].Source == "" {
+		t.Error("missing source")
+	}
+
+	rec.Reset()
+	if got := len(rec.Records()); got != 0 {
+		t.Errorf("got: %d records after Reset", got)
+	}
+}
+
+func TestValue(t *testing.T) {
+	r := Record{Attrs: map[string]any{
+		"a.b": "dotted",
+		"a": map[string]any{
+			"c": map[string]any{"d": "nested"},
+		},
+	}}
+	for path, want := range map[string]any{
+		"a.b":   "dotted",
+		"a.c.d": "nested",
+		"a.x":   nil,
+	} {
+		got, ok := r.Value(path)
+		if ok != (want != nil) || got != want {
+			t.Errorf("%s: got: %v (%v), want: %v", path, got, ok, want)
+		}
+	}
+}
+
+func TestGolden(t *testing.T) {
+	for name, opts := range map[string]zlog.Options{
+		"json":  {},
+		"prose": {ProseFormat: true},
+		"location": {
+			ProseFormat: true,
+			Prose:       &zlog.ProseOptions{SourceLocation: true},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts.Level = zlog.LevelEverything
+			rec := NewRecorder(&opts)
+			log := slog.New(rec)
+			for i := 0; i < 3; i++ {
+				log.Info(fmt.Sprintf("message %d", i), "i", i)
+			}
+			log.WithGroup("g").Warn("grouped", "k", "v")
+			CompareGolden(t, filepath.Join("testdata", name+".golden"), rec.Golden())
+		})
+	}
+}
+
+func TestRecorderFlightBuffer(t *testing.T) {
+	var calls atomic.Int64
+	rec := NewRecorder(&zlog.Options{
+		Level: slog.LevelInfo,
+		Middleware: []zlog.Middleware{
+			func(context.Context, *zlog.RecordView) bool {
+				calls.Add(1)
+				return true
+			},
+		},
+	})
+	log := slog.New(rec)
+	_, _, line, _ := runtime.Caller(0)
+	held := func(ctx context.Context, i int) { log.DebugContext(ctx, "held", "i", i) }
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := zlog.WithFlightBuffer(context.Background())
+			held(ctx, i)
+			zlog.FlushFlightBuffer(ctx)
+		}()
+	}
+	wg.Wait()
+
+	recs := rec.Records()
+	if got, want := len(recs), 4; got != want {
+		t.Fatalf("got: %d records, want: %d", got, want)
+	}
+	for _, r := range recs {
+		if got, want := r.Source, fmt.Sprintf(":%d", line+1); !strings.HasSuffix(got, want) {
+			t.Errorf("got: %q, want suffix: %q", got, want)
+		}
+	}
+	if got, want := calls.Load(), int64(4); got != want {
+		t.Errorf("middleware called %d times, want %d", got, want)
+	}
+	if got, want := bytes.Count(rec.Golden(), []byte("\n")), 4; got != want {
+		t.Errorf("got: %d golden lines, want: %d", got, want)
+	}
+}
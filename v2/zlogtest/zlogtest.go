@@ -0,0 +1,314 @@
+// Package zlogtest provides helpers for using the handlers from
+// [github.com/quay/zlog/v2] in tests.
+//
+// Records are routed to the [testing.TB] associated with the
+// [context.Context] used to log them, so output from parallel tests stays
+// orderly:
+//
+//	func TestMain(m *testing.M) {
+//		slog.SetDefault(slog.New(zlogtest.Handler(nil)))
+//		os.Exit(m.Run())
+//	}
+//
+//	func TestThing(t *testing.T) {
+//		ctx := zlogtest.Context(t)
+//		slog.InfoContext(ctx, "hello")
+//	}
+package zlogtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog/v2"
+	"github.com/quay/zlog/v2/internal/hooks"
+)
+
+// Context returns a Context associated with the test "t". Records logged with
+// the returned Context (or one derived from it) via a [Handler] are logged to
+// "t".
+//
+// Calling Context multiple times for the same test returns Contexts
+// associated with the same log stream.
+func Context(t testing.TB) context.Context {
+	t.Helper()
+	return WithContext(context.Background(), t)
+}
+
+// WithContext is like [Context], but derives the returned Context from "ctx".
+func WithContext(ctx context.Context, t testing.TB) context.Context {
+	t.Helper()
+	return context.WithValue(ctx, streamKey{}, getStream(t))
+}
+
+// Records returns the records logged so far for the test "t".
+func Records(t testing.TB) []Record {
+	registry.Lock()
+	s, ok := registry.m[t]
+	registry.Unlock()
+	if !ok {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	return append([]Record(nil), s.recs...)
+}
+
+// Record is a decoded log record.
+type Record struct {
+	// Time is the time of the record, if present.
+	Time time.Time
+	// Attrs are the record's Attrs, decoded from JSON. Groups are represented
+	// as nested maps.
+	Attrs map[string]any
+	// Message is the record's message.
+	Message string
+	// Source is the record's source information, if present.
+	Source string
+	// Level is the record's level.
+	Level slog.Level
+}
+
+// Handler returns an [slog.Handler] that formats records according to "opts"
+// and logs them to the test associated with the [context.Context] passed to
+// it. See [Context].
+//
+// Source information is reported as a "file:line" prefix on every line, as
+// the location reported by [testing.T.Log] is not useful. Records logged with
+// a Context not associated with a test, or after the associated test has
+// finished, are written to [os.Stderr].
+//
+// The test is found from the Context a record is emitted with, so records
+// held in a flight buffer (see [zlog.WithFlightBuffer]) are logged to the
+// right test whenever they're emitted.
+//
+// If "nil" is passed for options, suitable defaults will be used.
+func Handler(opts *zlog.Options) slog.Handler {
+	if opts == nil {
+		opts = &zlog.Options{Level: zlog.LevelEverything}
+	}
+	out := *opts
+	out.OmitSource = true
+	dec := *opts
+	dec.ProseFormat = false
+	dec.OmitSource = true
+	return &handler{
+		out: zlog.NewHandler(testWriter{}, &out),
+		dec: zlog.NewHandler(decodeWriter{}, &dec),
+	}
+}
+
+// Handler is the slog.Handler returned by [Handler].
+//
+// Records go through the pipeline of "out" once. As each is written, it's
+// emitted again by "dec" as JSON to be decoded into a [Record].
+type handler struct {
+	out, dec slog.Handler
+}
+
+// Enabled implements [slog.Handler].
+func (h *handler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.out.Enabled(ctx, l)
+}
+
+// Handle implements [slog.Handler].
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	return h.out.Handle(context.WithValue(ctx, pendingKey{}, &pending{dec: h.dec}), r)
+}
+
+// WithAttrs implements [slog.Handler].
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{
+		out: h.out.WithAttrs(attrs),
+		dec: h.dec.WithAttrs(attrs),
+	}
+}
+
+// WithGroup implements [slog.Handler].
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{
+		out: h.out.WithGroup(name),
+		dec: h.dec.WithGroup(name),
+	}
+}
+
+// PendingKey is the Context key for a *pending.
+type pendingKey struct{}
+
+// Pending is the state for one record, carried in the Context it's emitted
+// with.
+type pending struct {
+	// Dec is the Handler that emits the record as JSON.
+	dec slog.Handler
+	// Golden is the Handler that emits the normalized record, for a
+	// [Recorder].
+	golden slog.Handler
+	// Rec is the decoded record.
+	rec Record
+}
+
+// TestWriter logs the output of a Handler to the test associated with the
+// Context of each record.
+type testWriter struct{}
+
+var _ hooks.RecordWriter = testWriter{}
+
+// Write implements [io.Writer]. It's only used for output without a record.
+func (testWriter) Write(b []byte) (int, error) {
+	return os.Stderr.Write(b)
+}
+
+// WriteRecord implements [hooks.RecordWriter].
+func (testWriter) WriteRecord(ctx context.Context, r slog.Record, b []byte) (int, error) {
+	p, ok := ctx.Value(pendingKey{}).(*pending)
+	if !ok {
+		return os.Stderr.Write(b)
+	}
+	if err := hooks.Emit(p.dec, ctx, r); err != nil {
+		return 0, err
+	}
+	line := string(bytes.TrimRight(b, "\n"))
+	if p.rec.Source != "" {
+		line = filepath.Base(p.rec.Source) + ": " + line
+	}
+	s, _ := ctx.Value(streamKey{}).(*stream)
+	if s == nil {
+		s = orphans
+	}
+	if !s.Log(line, p.rec) {
+		if _, err := io.WriteString(os.Stderr, line+"\n"); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// DecodeWriter decodes the JSON output of a Handler into the pending record.
+type decodeWriter struct{}
+
+var _ hooks.RecordWriter = decodeWriter{}
+
+// Write implements [io.Writer]. It's only used for output without a record.
+func (decodeWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// WriteRecord implements [hooks.RecordWriter].
+func (decodeWriter) WriteRecord(ctx context.Context, r slog.Record, b []byte) (int, error) {
+	rec, err := decodeRecord(b)
+	if err != nil {
+		return 0, err
+	}
+	rec.Source = sourceOf(r.PC)
+	if p, ok := ctx.Value(pendingKey{}).(*pending); ok {
+		p.rec = rec
+	}
+	return len(b), nil
+}
+
+// SourceOf returns the "file:line" location for "pc", or the empty string if
+// it can't be determined.
+func sourceOf(pc uintptr) string {
+	if pc == 0 {
+		return ""
+	}
+	f, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	if f.File == "" {
+		return ""
+	}
+	return f.File + ":" + strconv.Itoa(f.Line)
+}
+
+// DecodeRecord decodes a JSON record.
+func decodeRecord(b []byte) (Record, error) {
+	var rec Record
+	m := make(map[string]any)
+	if err := json.Unmarshal(b, &m); err != nil {
+		return rec, fmt.Errorf("zlogtest: unable to decode record: %w", err)
+	}
+	if v, ok := m[slog.LevelKey].(string); ok {
+		if err := rec.Level.UnmarshalText([]byte(v)); err != nil {
+			return rec, fmt.Errorf("zlogtest: unable to decode record: %w", err)
+		}
+		delete(m, slog.LevelKey)
+	}
+	if v, ok := m[slog.MessageKey].(string); ok {
+		rec.Message = v
+		delete(m, slog.MessageKey)
+	}
+	if v, ok := m[slog.TimeKey].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			rec.Time = t
+			delete(m, slog.TimeKey)
+		}
+	}
+	rec.Attrs = m
+	return rec, nil
+}
+
+// StreamKey is the Context key for a *stream.
+type streamKey struct{}
+
+// Stream is the per-test state.
+type stream struct {
+	sync.Mutex
+	t    testing.TB
+	done bool
+	recs []Record
+}
+
+// Orphans is the stream for records not associated with a test. It's always
+// done, so records are written to os.Stderr.
+var orphans = &stream{done: true}
+
+// Log logs "line" to the test and adds "rec" to its records, reporting false
+// if the test has finished.
+func (s *stream) Log(line string, rec Record) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.done {
+		return false
+	}
+	s.recs = append(s.recs, rec)
+	s.t.Log(line)
+	return true
+}
+
+// Registry holds the streams for all running tests.
+var registry = struct {
+	sync.Mutex
+	m map[testing.TB]*stream
+}{
+	m: make(map[testing.TB]*stream),
+}
+
+// GetStream returns the stream for "t", creating it if needed.
+func getStream(t testing.TB) *stream {
+	registry.Lock()
+	defer registry.Unlock()
+	if s, ok := registry.m[t]; ok {
+		return s
+	}
+	s := &stream{t: t}
+	registry.m[t] = s
+	t.Cleanup(func() {
+		s.Lock()
+		s.done = true
+		s.Unlock()
+		registry.Lock()
+		delete(registry.m, t)
+		registry.Unlock()
+	})
+	return s
+}
@@ -0,0 +1,158 @@
+package zlogtest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog/v2"
+)
+
+type recordingTB struct {
+	testing.TB
+	logs []string
+}
+
+func (r *recordingTB) Log(args ...any) { r.logs = append(r.logs, fmt.Sprint(args...)) }
+
+func TestRouting(t *testing.T) {
+	log := slog.New(Handler(nil)).With("shared", true)
+	for i := 0; i < 4; i++ {
+		name := strconv.Itoa(i)
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := Context(t)
+			for j := 0; j < 10; j++ {
+				log.InfoContext(ctx, name, "j", j)
+			}
+			recs := Records(t)
+			if got, want := len(recs), 10; got != want {
+				t.Fatalf("got: %d records, want: %d", got, want)
+			}
+			for j, r := range recs {
+				if r.Message != name {
+					t.Errorf("got message %q in test %q", r.Message, name)
+				}
+				if got, want := r.Attrs["j"], float64(j); got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+				if r.Attrs["shared"] != true {
+					t.Errorf("missing attr: %v", r.Attrs)
+				}
+			}
+		})
+	}
+}
+
+func TestOutput(t *testing.T) {
+	for _, prose := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Prose=%v", prose), func(t *testing.T) {
+			tb := &recordingTB{TB: t}
+			ctx := Context(tb)
+			h := Handler(&zlog.Options{
+				Level:       slog.LevelInfo,
+				OmitTime:    true,
+				ProseFormat: prose,
+			})
+			log := slog.New(h).WithGroup("g")
+			log.DebugContext(ctx, "hidden")
+			_, file, line, _ := runtime.Caller(0)
+			log.WarnContext(ctx, "shown", "k", "v")
+
+			if got, want := len(tb.logs), 1; got != want {
+				t.Fatalf("got: %d logs, want: %d: %q", got, want, tb.logs)
+			}
+			out := tb.logs[0]
+			t.Log(out)
+			prefix := fmt.Sprintf("zlogtest_test.go:%d: ", line+1)
+			if !strings.HasPrefix(out, prefix) {
+				t.Errorf("missing prefix %q: %q", prefix, out)
+			}
+			if !strings.Contains(out, "shown") {
+				t.Errorf("missing message: %q", out)
+			}
+
+			recs := Records(tb)
+			if got, want := len(recs), 1; got != want {
+				t.Fatalf("got: %d records, want: %d", got, want)
+			}
+			r := recs[0]
+			if got, want := r.Level, slog.LevelWarn; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			if got, want := r.Source, fmt.Sprintf("%s:%d", file, line+1); got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			g, _ := r.Attrs["g"].(map[string]any)
+			if got, want := g["k"], "v"; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFlightBuffer(t *testing.T) {
+	var calls atomic.Int64
+	h := Handler(&zlog.Options{
+		Level: slog.LevelInfo,
+		Middleware: []zlog.Middleware{
+			func(context.Context, *zlog.RecordView) bool {
+				calls.Add(1)
+				return true
+			},
+		},
+	})
+	log := slog.New(h)
+	for i := 0; i < 4; i++ {
+		name := strconv.Itoa(i)
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := zlog.WithFlightBuffer(Context(t))
+			log.DebugContext(ctx, "held", "test", name)
+			if got := len(Records(t)); got != 0 {
+				t.Fatalf("got: %d records, want: 0", got)
+			}
+			// Flushing outside of a call to Handle still logs to this test.
+			zlog.FlushFlightBuffer(ctx)
+			recs := Records(t)
+			if got, want := len(recs), 1; got != want {
+				t.Fatalf("got: %d records, want: %d", got, want)
+			}
+			if got := recs[0].Attrs["test"]; got != name {
+				t.Errorf("got: %v, want: %v", got, name)
+			}
+		})
+	}
+	t.Cleanup(func() {
+		if got, want := calls.Load(), int64(4); got != want {
+			t.Errorf("middleware called %d times, want %d", got, want)
+		}
+	})
+}
+
+func TestOutputValues(t *testing.T) {
+	tb := &recordingTB{TB: t}
+	ctx := Context(tb)
+	h := Handler(&zlog.Options{OmitTime: true, ProseFormat: true})
+	slog.New(h).InfoContext(ctx, "values",
+		"d", time.Second,
+		"u", uint64(math.MaxUint64),
+	)
+	if got, want := len(tb.logs), 1; got != want {
+		t.Fatalf("got: %d logs, want: %d: %q", got, want, tb.logs)
+	}
+	out := tb.logs[0]
+	t.Log(out)
+	for _, want := range []string{"d=1s", "u=18446744073709551615"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q: %q", want, out)
+		}
+	}
+}
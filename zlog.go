@@ -3,7 +3,8 @@
 // It uses opentelemetry baggage to generate log contexts.
 //
 // By default, the package wraps the zerolog global logger. This can be changed
-// via the Set function.
+// via the Set function, or the events can be sent to an slog.Handler via the
+// SetHandler function.
 //
 // In addition, a testing adapter is provided to keep testing logs orderly.
 package zlog
@@ -14,6 +15,7 @@ import (
 	"github.com/rs/zerolog"
 	global "github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Log is the logger used by the package-level functions.
@@ -25,56 +27,177 @@ var log = &global.Logger
 // package.
 func Set(l *zerolog.Logger) {
 	log = l
+	handler = nil
+}
+
+// Options is used to configure the contextual information added to messages
+// by the package-level functions. See [SetOptions].
+//
+// The zero value adds every OpenTelemetry Baggage member as a top-level field
+// and adds the trace and span IDs of a recording span.
+type Options struct {
+	// Baggage is a selection function for keys in the OpenTelemetry Baggage
+	// contained in the [context.Context] used with a log message. If nil,
+	// all members are selected.
+	//
+	// Baggage member values are always percent-decoded.
+	Baggage func(key string) bool
+	// BaggageKey, if not empty, causes the selected Baggage members to be
+	// added as a dictionary at this key instead of as top-level fields. This
+	// prevents the members from colliding with the message's own fields.
+	BaggageKey string
+	// OmitTrace controls whether the trace and span IDs of the span contained
+	// in the [context.Context] used with a log message should be omitted.
+	//
+	// The field names are "TraceID" and "SpanID", to match the v2 package.
+	OmitTrace bool
+}
+
+// Opts is the configuration used by addCtx.
+var opts Options
+
+// SetOptions configures the contextual information added by the
+// package-level functions.
+//
+// This function is unsafe to use concurrently with the other functions of this
+// package.
+func SetOptions(o *Options) {
+	if o == nil {
+		o = &Options{}
+	}
+	opts = *o
+}
+
+// BaggageAllowlist returns a selection function for use as [Options.Baggage]
+// that selects only the provided keys.
+func BaggageAllowlist(keys ...string) func(string) bool {
+	set := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		set[k] = struct{}{}
+	}
+	return func(k string) bool {
+		_, ok := set[k]
+		return ok
+	}
 }
 
 // AddCtx is the workhorse function that every facade function calls.
 //
-// If the passed Event is enabled, it will attach all the otel baggage to
-// it and return it.
+// If the passed Event is enabled, it will attach the trace information and the
+// selected otel baggage to it and return it.
 func addCtx(ctx context.Context, ev *zerolog.Event) *zerolog.Event {
 	if !ev.Enabled() {
 		return ev
 	}
 
+	if !opts.OmitTrace {
+		if span := trace.SpanFromContext(ctx); span.IsRecording() {
+			sCtx := span.SpanContext()
+			if sCtx.HasTraceID() {
+				ev.Str(`TraceID`, sCtx.TraceID().String())
+			}
+			if sCtx.HasSpanID() {
+				ev.Str(`SpanID`, sCtx.SpanID().String())
+			}
+		}
+	}
+
+	var dict *zerolog.Event
 	b := baggage.FromContext(ctx)
 	for _, m := range b.Members() {
-		ev.Str(m.Key(), m.Value())
+		k := m.Key()
+		switch {
+		case k == testNameKey:
+			// The test harness needs this at the top level, no matter the
+			// configuration.
+			ev.Str(k, m.Value())
+			continue
+		case opts.Baggage != nil && !opts.Baggage(k):
+			continue
+		case opts.BaggageKey != "":
+			if dict == nil {
+				dict = zerolog.Dict()
+			}
+			dict.Str(k, m.Value())
+		default:
+			ev.Str(k, m.Value())
+		}
+	}
+	if dict != nil {
+		ev.Dict(opts.BaggageKey, dict)
 	}
 
 	return ev
 }
 
+// NewEvent starts a new message with the specified level, using the configured
+// logger or Handler.
+//
+// A level stored in the Context by [ContextWithLevel] takes precedence over
+// the logger's or Handler's level.
+func newEvent(ctx context.Context, l zerolog.Level) *zerolog.Event {
+	cl, override := ctx.Value(levelKey{}).(zerolog.Level)
+	if h := handler; h != nil {
+		if override {
+			if l < cl || l < zerolog.GlobalLevel() {
+				return nil
+			}
+			zl := zerolog.New(&slogWriter{h: h, ctx: ctx})
+			return zl.WithLevel(l)
+		}
+		return handlerEvent(ctx, h, l)
+	}
+	if override {
+		zl := log.Level(cl)
+		return addCtx(ctx, zl.WithLevel(l))
+	}
+	return addCtx(ctx, log.WithLevel(l))
+}
+
+// LevelKey is the Context key for a level set by ContextWithLevel.
+type levelKey struct{}
+
+// ContextWithLevel returns a Context that overrides the minimum level of
+// messages started by the package-level functions.
+//
+// This allows, for example, a single request to be logged at debug level
+// without enabling it globally. The zerolog global level (see
+// [zerolog.SetGlobalLevel]) still applies.
+func ContextWithLevel(ctx context.Context, l zerolog.Level) context.Context {
+	return context.WithValue(ctx, levelKey{}, l)
+}
+
 // Log starts a new message with no level.
 func Log(ctx context.Context) *zerolog.Event {
-	return addCtx(ctx, log.Log())
+	return newEvent(ctx, zerolog.NoLevel)
 }
 
 // WithLevel starts a new message with the specified level.
 func WithLevel(ctx context.Context, l zerolog.Level) *zerolog.Event {
-	return addCtx(ctx, log.WithLevel(l))
+	return newEvent(ctx, l)
 }
 
 // Trace starts a new message with the trace level.
 func Trace(ctx context.Context) *zerolog.Event {
-	return addCtx(ctx, log.Trace())
+	return newEvent(ctx, zerolog.TraceLevel)
 }
 
 // Debug starts a new message with the debug level.
 func Debug(ctx context.Context) *zerolog.Event {
-	return addCtx(ctx, log.Debug())
+	return newEvent(ctx, zerolog.DebugLevel)
 }
 
 // Info starts a new message with the infor level.
 func Info(ctx context.Context) *zerolog.Event {
-	return addCtx(ctx, log.Info())
+	return newEvent(ctx, zerolog.InfoLevel)
 }
 
 // Warn starts a new message with the warn level.
 func Warn(ctx context.Context) *zerolog.Event {
-	return addCtx(ctx, log.Warn())
+	return newEvent(ctx, zerolog.WarnLevel)
 }
 
 // Error starts a new message with the error level.
 func Error(ctx context.Context) *zerolog.Event {
-	return addCtx(ctx, log.Error())
+	return newEvent(ctx, zerolog.ErrorLevel)
 }
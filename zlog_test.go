@@ -4,12 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func TestEscape(t *testing.T) {
@@ -107,6 +112,7 @@ func TestContext(t *testing.T) {
 	var buf bytes.Buffer
 	var got, want map[string]string
 	l := zerolog.New(&buf)
+	defer Set(log)
 	Set(&l)
 	ctx := Test(context.Background(), t)
 
@@ -210,3 +216,301 @@ func ExampleContextWithValues() {
 	Log(ctx).Msg("message")
 	// Can't capture the output because iteration order isn't guarenteed.
 }
+
+func TestContextWithLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := zerolog.New(&buf).Level(zerolog.InfoLevel)
+	defer Set(log)
+	Set(&l)
+	ctx := context.Background()
+
+	Debug(ctx).Msg("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+	dctx := ContextWithLevel(ctx, zerolog.DebugLevel)
+	Debug(dctx).Msg("shown")
+	Trace(dctx).Msg("hidden")
+	if got, want := buf.String(), `{"level":"debug","message":"shown"}`+"\n"; got != want {
+		t.Errorf("got: %#q, want: %#q", got, want)
+	}
+	buf.Reset()
+	Info(ContextWithLevel(ctx, zerolog.WarnLevel)).Msg("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+// RecordingSpan is a span that claims to be recording.
+type recordingSpan struct {
+	noop.Span
+	sc trace.SpanContext
+}
+
+func (s recordingSpan) IsRecording() bool              { return true }
+func (s recordingSpan) SpanContext() trace.SpanContext { return s.sc }
+
+func TestFacadeOptions(t *testing.T) {
+	var buf bytes.Buffer
+	l := zerolog.New(&buf)
+	defer Set(log)
+	defer SetOptions(nil)
+	Set(&l)
+
+	ctx := ContextWithValues(context.Background(),
+		"keep", "20% done",
+		"drop", "secret")
+	ctx = trace.ContextWithSpan(ctx, recordingSpan{
+		sc: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{0x01},
+			SpanID:  trace.SpanID{0x02},
+		}),
+	})
+	tt := []struct {
+		Name string
+		Opts Options
+		Want map[string]any
+	}{
+		{
+			Name: "Default",
+			Want: map[string]any{
+				"TraceID": "01000000000000000000000000000000",
+				"SpanID":  "0200000000000000",
+				"keep":    "20% done",
+				"drop":    "secret",
+				"message": "message",
+			},
+		},
+		{
+			Name: "Selected",
+			Opts: Options{
+				Baggage:    BaggageAllowlist("keep"),
+				BaggageKey: "baggage",
+				OmitTrace:  true,
+			},
+			Want: map[string]any{
+				"baggage": map[string]any{"keep": "20% done"},
+				"message": "message",
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			buf.Reset()
+			SetOptions(&tc.Opts)
+			Log(ctx).Msg("message")
+			got := make(map[string]any)
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got, tc.Want) {
+				t.Error(cmp.Diff(got, tc.Want))
+			}
+		})
+	}
+}
+
+func TestRecords(t *testing.T) {
+	ctx := Test(context.Background(), t)
+	Expect(t).Level(zerolog.WarnLevel).Msg("retrying").Field("attempt", 2)
+	Expect(t).Msg("retrying").Times(2)
+	Expect(t).Level(zerolog.ErrorLevel).Never()
+	NoErrors(t)
+
+	Warn(ctx).Int("attempt", 1).Msg("retrying")
+	Warn(ctx).Int("attempt", 2).Msg("retrying")
+	Info(ctx).Msg("done")
+
+	rs := Records(t)
+	if got, want := len(rs), 3; got != want {
+		t.Fatalf("got: %d records, want: %d", got, want)
+	}
+	if got, want := rs[2], (Record{Level: zerolog.InfoLevel, Message: "done", Fields: map[string]any{"caller": rs[2].Fields["caller"]}}); !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+}
+
+func TestGoroutineAttribution(t *testing.T) {
+	Test(context.Background(), t)
+	Expect(t).Msg("from goroutine")
+	Expect(t).Msg("from grandchild goroutine")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// No test Context here.
+		Log(context.Background()).Msg("from goroutine")
+		inner := make(chan struct{})
+		go func() {
+			defer close(inner)
+			Log(context.Background()).Msg("from grandchild goroutine")
+		}()
+		<-inner
+	}()
+	<-done
+}
+
+func TestGoroutineAttributionIndirect(t *testing.T) {
+	Test(context.Background(), t)
+	Expect(t).Msg("from grandchild goroutine")
+	done := make(chan struct{})
+	go func() {
+		// The intermediate goroutine never logs, and exits first.
+		go func() {
+			defer close(done)
+			Log(context.Background()).Msg("from grandchild goroutine")
+		}()
+	}()
+	<-done
+}
+
+func TestGoroutineAttributionConcurrent(t *testing.T) {
+	Test(context.Background(), t)
+	const n = 8
+	Expect(t).Msg("concurrent").Times(n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Log(context.Background()).Int("i", i).Msg("concurrent")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestOrphans(t *testing.T) {
+	setup.Do(sink.Setup)
+	defer SetOrphanPolicy(OrphanPanic, nil)
+	orphan := []byte(`{"message":"orphan"}` + "\n")
+
+	t.Run("Drop", func(t *testing.T) {
+		SetOrphanPolicy(OrphanDrop, nil)
+		before := DroppedOrphans()
+		if _, err := sink.Write(orphan); err != nil {
+			t.Error(err)
+		}
+		if _, err := sink.Write([]byte("not json\n")); err != nil {
+			t.Error(err)
+		}
+		if got, want := DroppedOrphans()-before, 2; got != want {
+			t.Errorf("got: %d, want: %d", got, want)
+		}
+	})
+	t.Run("Buffer", func(t *testing.T) {
+		SetOrphanPolicy(OrphanBuffer, nil)
+		sink.Write(orphan)
+		Test(context.Background(), t)
+		ClaimOrphans(t)
+		Expect(t).Msg("orphan").Times(1)
+	})
+	t.Run("Fallback", func(t *testing.T) {
+		SetOrphanPolicy(OrphanFallback, t)
+		if _, err := sink.Write(orphan); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Panic", func(t *testing.T) {
+		SetOrphanPolicy(OrphanPanic, nil)
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic")
+			}
+		}()
+		sink.Write(orphan)
+	})
+}
+
+// RecordingTB records calls to Log and reports a settable failure state.
+type recordingTB struct {
+	testing.TB
+	failed bool
+	logs   []string
+}
+
+func (r *recordingTB) Name() string               { return r.TB.Name() + "/recording" }
+func (r *recordingTB) Failed() bool               { return r.failed }
+func (r *recordingTB) Log(args ...any)            { r.logs = append(r.logs, fmt.Sprint(args...)) }
+func (r *recordingTB) Logf(f string, args ...any) { r.logs = append(r.logs, fmt.Sprintf(f, args...)) }
+
+func TestFailedOnly(t *testing.T) {
+	info := zerolog.InfoLevel
+	opts := TestOptions{
+		FailedOnly: true,
+		MinLevel:   &info,
+		Keep:       2,
+	}
+	for _, failed := range []bool{false, true} {
+		tb := &recordingTB{TB: t, failed: failed}
+		ctx := TestWithOptions(context.Background(), tb, &opts)
+		Debug(ctx).Msg("too low")
+		Info(ctx).Msg("one")
+		Info(ctx).Msg("two")
+		Info(ctx).Msg("three")
+		if got := len(Records(tb)); got != 4 {
+			t.Errorf("got: %d records, want: 4", got)
+		}
+		if len(tb.logs) != 0 {
+			t.Errorf("unexpected logs: %q", tb.logs)
+		}
+		sink.Remove(tb)
+		if !failed {
+			if len(tb.logs) != 0 {
+				t.Errorf("unexpected logs: %q", tb.logs)
+			}
+			continue
+		}
+		if got, want := len(tb.logs), 3; got != want {
+			t.Fatalf("got: %d logs, want: %d: %q", got, want, tb.logs)
+		}
+		for i, want := range []string{"two", "three"} {
+			if got := tb.logs[i+1]; !strings.Contains(got, want) {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		}
+	}
+}
+
+// TestFailedOnlyTrace checks that FailedOnly alone holds every message.
+func TestFailedOnlyTrace(t *testing.T) {
+	tb := &recordingTB{TB: t, failed: true}
+	ctx := TestWithOptions(context.Background(), tb, &TestOptions{FailedOnly: true})
+	Trace(ctx).Msg("trace")
+	sink.Remove(tb)
+	if got, want := len(tb.logs), 2; got != want {
+		t.Fatalf("got: %d logs, want: %d: %q", got, want, tb.logs)
+	}
+	if got := tb.logs[1]; !strings.Contains(got, "trace") {
+		t.Errorf("got: %q, want: %q", got, "trace")
+	}
+}
+
+// CleanupTB is a recordingTB that holds its cleanups and records errors.
+type cleanupTB struct {
+	recordingTB
+	cleanups []func()
+	errs     []string
+}
+
+func (c *cleanupTB) Helper()                      {}
+func (c *cleanupTB) Cleanup(f func())             { c.cleanups = append(c.cleanups, f) }
+func (c *cleanupTB) Errorf(f string, args ...any) { c.errs = append(c.errs, fmt.Sprintf(f, args...)) }
+
+// TestExpectAfterRemove checks that expectations still see the records if the
+// stream is removed before they're checked.
+func TestExpectAfterRemove(t *testing.T) {
+	tb := &cleanupTB{recordingTB: recordingTB{TB: t}}
+	ctx := Test(context.Background(), tb)
+	Expect(tb).Msg("present")
+	Expect(tb).Msg("bad").Never()
+	NoErrors(tb)
+	Log(ctx).Msg("present")
+	Error(ctx).Msg("bad")
+
+	sink.Remove(tb)
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+	if got, want := len(tb.errs), 2; got != want {
+		t.Errorf("got: %d errors, want: %d: %q", got, want, tb.errs)
+	}
+}